@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"aaaas/pipeline-api/pkg/api/handlers"
+)
+
+// filter serves the filter service that attribute conditions on fork branches are sent to. It is meant to run as a
+// knative service in the namespace of the pipelines, with its address set as filter_uri in conf/api.conf.
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Printf("Filter service listening on :%s", port)
+	log.Fatal(http.ListenAndServe(":"+port, handlers.FilterHandler{}))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"aaaas/pipeline-api/pkg/api/handlers"
+)
+
+// step serves the step service the broker backend calls functions through. It is meant to run as a knative
+// service in the namespace of the pipelines, set in NAMESPACE, with its address set as step_uri in conf/api.conf.
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	namespace := os.Getenv("NAMESPACE")
+	if namespace == "" {
+		log.Fatal("NAMESPACE must be set to the namespace of the functions")
+	}
+	log.Printf("Step service listening on :%s for functions in %s", port, namespace)
+	log.Fatal(http.ListenAndServe(":"+port, handlers.StepHandler{Namespace: namespace}))
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+
 	"aaaas/pipeline-api/pkg/api/config"
 	"aaaas/pipeline-api/pkg/api/handlers"
 
@@ -12,8 +14,26 @@ func main() {
 	configPath := "conf/api.conf"
 	configSections := []string{"server"}
 	serverCfgImpl := config.NewServerConfigImpl()
+	if err := config.LoadServerConfig(configPath, configSections, serverCfgImpl); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	// one client is shared by every request
+	k8sClient, err := handlers.NewK8sClient(serverCfgImpl)
+	if err != nil {
+		log.Fatalf("Error creating Kubernetes client: %v", err)
+	}
+	channel, err := serverCfgImpl.ChannelDefaults()
+	if err != nil {
+		log.Fatalf("Error loading channel config: %v", err)
+	}
 	middlewareConf := commonCfg.NewMiddlewareConfig(commonCfg.DisableKubeconfigMiddleware())
-	routes := []server.APIHandlerGroup{handlers.HandlerGroup{}}
+	routes := []server.APIHandlerGroup{handlers.NewHandlerGroup(k8sClient,
+		handlers.WithDeliveryDefaults(serverCfgImpl.DeliveryDefaults()),
+		handlers.WithChannelDefaults(channel),
+		handlers.WithFilterURI(serverCfgImpl.FilterURI),
+		handlers.WithStepURI(serverCfgImpl.StepURI),
+		handlers.WithBackend(serverCfgImpl.Backend),
+	)}
 	// server.Run(configPath, configSections, routes, serverCfgImpl, "")
-	server.RunWithMiddlewareConfigs(configPath, configSections, routes, serverCfgImpl, "conf/supervisorconf", middlewareConf)
+	server.RunWithMiddlewareConfigs(configPath, configSections, routes, serverCfgImpl, serverCfgImpl.KubeConfigUrl, middlewareConf)
 }
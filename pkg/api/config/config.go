@@ -1,7 +1,12 @@
 package config
 
 import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"encoding/json"
+	"fmt"
+
 	cfg "github.com/pcs-aa-aas/commons/pkg/api/config"
+	"gopkg.in/ini.v1"
 )
 
 type ServerConfigImpl struct {
@@ -11,6 +16,27 @@ type ServerConfigImpl struct {
 	SwaggerPath       string `ini:"swagger_path"`
 	TokenTTL          string `ini:"token_ttl"`
 	KubeConfigUrl     string `ini:"kubeconfig_url"`
+	// InCluster uses the pod's service account instead of a kubeconfig
+	InCluster bool `ini:"in_cluster"`
+	// KubeQPS and KubeBurst rate limit the shared kubernetes client, client-go defaults apply when unset
+	KubeQPS   float64 `ini:"kube_qps"`
+	KubeBurst int     `ini:"kube_burst"`
+	// Delivery defaults for every pipeline, left out of the generated resources when unset
+	DeliveryRetry         int    `ini:"delivery_retry"`
+	DeliveryBackoffPolicy string `ini:"delivery_backoff_policy"`
+	DeliveryBackoffDelay  string `ini:"delivery_backoff_delay"`
+	DeliveryTimeout       string `ini:"delivery_timeout"`
+	DeadLetterURI         string `ini:"dead_letter_uri"`
+	// Channel used by every pipeline, InMemoryChannel when unset. ChannelSpec is a JSON object
+	ChannelAPIVersion string `ini:"channel_api_version"`
+	ChannelKind       string `ini:"channel_kind"`
+	ChannelSpec       string `ini:"channel_spec"`
+	// Service evaluating the attribute conditions on fork branches
+	FilterURI string `ini:"filter_uri"`
+	// Service relaying the calls to functions with the broker backend
+	StepURI string `ini:"step_uri"`
+	// Backend used by pipelines that do not pick one, "flows" when unset
+	Backend string `ini:"backend"`
 }
 
 func (sc *ServerConfigImpl) GetApiUri() string {
@@ -37,6 +63,60 @@ func (sc *ServerConfigImpl) GetKubeconfigUrl() string {
 	return sc.KubeConfigUrl
 }
 
+// DeliveryDefaults returns the configured delivery settings, nil when none are set
+func (sc *ServerConfigImpl) DeliveryDefaults() *model.DeliveryConfig {
+	delivery := &model.DeliveryConfig{
+		BackoffPolicy: sc.DeliveryBackoffPolicy,
+		BackoffDelay:  sc.DeliveryBackoffDelay,
+		Timeout:       sc.DeliveryTimeout,
+	}
+	if sc.DeliveryRetry > 0 {
+		retry := int32(sc.DeliveryRetry)
+		delivery.Retry = &retry
+	}
+	if sc.DeadLetterURI != "" {
+		delivery.DeadLetterSink = &model.DeadLetterConfig{
+			Type:       model.URINode,
+			SinkConfig: model.SinkConfig{URI: sc.DeadLetterURI},
+		}
+	}
+	if *delivery == (model.DeliveryConfig{}) {
+		return nil
+	}
+	return delivery
+}
+
+// ChannelDefaults returns the configured channel, nil when none is set
+func (sc *ServerConfigImpl) ChannelDefaults() (*model.ChannelConfig, error) {
+	if sc.ChannelKind == "" {
+		return nil, nil
+	}
+	channel := &model.ChannelConfig{
+		APIVersion: sc.ChannelAPIVersion,
+		Kind:       sc.ChannelKind,
+	}
+	if sc.ChannelSpec != "" {
+		if err := json.Unmarshal([]byte(sc.ChannelSpec), &channel.Spec); err != nil {
+			return nil, fmt.Errorf("invalid channel_spec: %w", err)
+		}
+	}
+	return channel, nil
+}
+
 func NewServerConfigImpl() *ServerConfigImpl {
 	return &ServerConfigImpl{}
 }
+
+// LoadServerConfig reads the given sections of the config file, so settings needed before the server starts are available
+func LoadServerConfig(path string, sections []string, sc *ServerConfigImpl) error {
+	file, err := ini.Load(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config %s: %w", path, err)
+	}
+	for _, section := range sections {
+		if err := file.Section(section).MapTo(sc); err != nil {
+			return fmt.Errorf("unable to parse section %s of config %s: %w", section, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
+	"knative.dev/pkg/apis"
+	duck "knative.dev/pkg/apis/duck/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// StepExtension is the CloudEvent extension the broker backend routes on, it names the node an event comes from.
+	// Functions are called through the step service, which sets it on their reply.
+	StepExtension = "mochastep"
+	// EntryStep is the StepExtension value of events sent into a broker pipeline from outside
+	EntryStep = "entry"
+)
+
+// brokerTranslator routes events between the functions through one broker with a trigger per edge
+type brokerTranslator struct{}
+
+// Validate rejects filter function conditions, triggers can only match attributes, functions without
+// a step service to call them through and nodes whose id would be taken for events entering the pipeline
+func (brokerTranslator) Validate(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload) []model.ValidationError {
+	errs := []model.ValidationError{}
+	for _, node := range payload.Nodes {
+		if node.ID == EntryStep {
+			errs = append(errs, model.ValidationError{
+				Code:    helpers.ReservedNodeID,
+				Message: fmt.Sprintf("node id %s is the step of the events sent to the pipeline with the broker backend", EntryStep),
+				NodeID:  node.ID,
+			})
+		}
+	}
+	if payload.StepURI == "" {
+		for _, node := range payload.Nodes {
+			if !helpers.IsSource(node) && !helpers.IsSink(node) {
+				errs = append(errs, model.ValidationError{
+					Code:    helpers.InvalidBackend,
+					Message: "the broker backend calls functions through a step service, none is configured",
+				})
+				break
+			}
+		}
+	}
+	for _, edge := range payload.Edges {
+		if edge.Condition != nil && edge.Condition.FaasID != "" {
+			errs = append(errs, model.ValidationError{
+				Code:    helpers.InvalidCondition,
+				Message: fmt.Sprintf("condition on edge %s uses a filter function, the broker backend only filters on attributes", edge.ID),
+				EdgeID:  edge.ID,
+			})
+		}
+	}
+	return errs
+}
+
+// Kinds are the broker and its triggers along with the sources
+func (brokerTranslator) Kinds() []schema.GroupVersionKind {
+	kinds := []schema.GroupVersionKind{
+		brokerKind,
+		triggerKind,
+	}
+	return append(kinds, sourceGVKs()...)
+}
+
+// Translate works out a single broker with a trigger per edge, plus one into the root node for the events
+// sent to the pipeline. Sources deliver to the broker with their node id set in the StepExtension.
+func (brokerTranslator) Translate(ctx context.Context, k8sClient client.Client, graph *Graph) ([]client.Object, error) {
+	payload, namespace, sinks, pipelineId := graph.PipelinePayload, graph.Namespace, graph.Sinks, graph.PipelineID
+	// every function has to be backed by a ksvc, as with sequences
+	functionIds := []string{}
+	for _, node := range payload.Nodes {
+		if !helpers.IsSource(node) && !helpers.IsSink(node) {
+			functionIds = append(functionIds, node.ID)
+		}
+	}
+	if _, err := GetValidNodes(ctx, k8sClient, namespace, functionIds, payload.Nodes); err != nil {
+		fmt.Println("Unable to validate nodes: ", err)
+		return nil, err
+	}
+
+	delivery, err := deliverySpec(payload.Delivery)
+	if err != nil {
+		return nil, err
+	}
+	broker := eventing.Broker{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        helpers.ResourceName(pipelineId, "broker"),
+			Namespace:   namespace,
+			Labels:      map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy},
+			Annotations: map[string]string{EntryAnnotation: "true"},
+		},
+		Spec: eventing.BrokerSpec{Delivery: delivery},
+	}
+	objects := []client.Object{&broker}
+
+	conditions := branchConditions(payload.Edges)
+	if graph.EntryNode != "" {
+		trigger, err := brokerTrigger(payload, namespace, pipelineId, broker.Name, EntryStep, graph.EntryNode, nil, sinks)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, trigger)
+	}
+	for _, edge := range payload.Edges {
+		trigger, err := brokerTrigger(payload, namespace, pipelineId, broker.Name, edge.Source, edge.Target, conditions[edge.Source], sinks)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, trigger)
+	}
+
+	brokerRef := duck.Destination{
+		Ref: &duck.KReference{
+			APIVersion: "eventing.knative.dev/v1",
+			Kind:       "Broker",
+			Name:       broker.Name,
+		},
+	}
+	sources, err := translateSources(payload, namespace, pipelineId, graph.SourceTargets, func(string) duck.Destination {
+		return brokerRef
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range sources {
+		extensions := map[string]string{StepExtension: sources[i].GetAnnotations()[SourceNodeAnnotation]}
+		if err := unstructured.SetNestedStringMap(sources[i].Object, extensions, "spec", "ceOverrides", "extensions"); err != nil {
+			return nil, fmt.Errorf("failed to set the step of source %s: %w", sources[i].GetName(), err)
+		}
+		objects = append(objects, &sources[i])
+	}
+	return objects, nil
+}
+
+// brokerTrigger builds the trigger carrying the events of one edge. It matches the StepExtension of the node
+// the edge leaves and any condition on the edge, from is EntryStep for the trigger into the root node.
+func brokerTrigger(payload model.PipelinePayload, namespace string, pipelineId string, brokerName string,
+	from string, to string, conditions map[string]*model.EdgeCondition, sinks map[string]duck.Destination) (*eventing.Trigger, error) {
+	target, err := GetNodeByID(payload.Nodes, to)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber, isSink := sinks[to]
+	deliveryConfig := payload.Delivery
+	if !isSink {
+		// the reply comes back through the step service, so it always names the node that produced it
+		uri, err := apis.ParseURL(payload.StepURI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step uri: %w", err)
+		}
+		query := uri.URL().Query()
+		query.Set(StepExtension, to)
+		query.Set(ServiceParam, target.Data.FaasID)
+		uri.RawQuery = query.Encode()
+		subscriber = duck.Destination{URI: uri}
+		deliveryConfig = mergeDelivery(payload.Delivery, target.Data.Delivery)
+	}
+	delivery, err := deliverySpec(deliveryConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []string{to}
+	if from != EntryStep {
+		nodes = []string{from, to}
+	}
+	trigger := &eventing.Trigger{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        helpers.ResourceName(pipelineId, "trigger", from, to),
+			Namespace:   namespace,
+			Labels:      map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy},
+			Annotations: map[string]string{NodesAnnotation: strings.Join(nodes, ",")},
+		},
+		Spec: eventing.TriggerSpec{
+			Broker:     brokerName,
+			Subscriber: *subscriber.DeepCopy(),
+			Delivery:   delivery,
+		},
+	}
+
+	step := map[string]string{StepExtension: from}
+	condition := conditions[to]
+	switch {
+	case condition == nil:
+		trigger.Spec.Filter = &eventing.TriggerFilter{Attributes: step}
+	case condition.Otherwise:
+		// attribute filters can not negate, the subscriptions api filters can
+		others := []string{}
+		for other := range conditions {
+			if other != to && len(conditions[other].Attributes) > 0 {
+				others = append(others, other)
+			}
+		}
+		sort.Strings(others)
+		filters := []eventing.SubscriptionsAPIFilter{{Exact: step}}
+		if len(others) > 0 {
+			excluded := []eventing.SubscriptionsAPIFilter{}
+			for _, other := range others {
+				excluded = append(excluded, eventing.SubscriptionsAPIFilter{Exact: conditions[other].Attributes})
+			}
+			filters = append(filters, eventing.SubscriptionsAPIFilter{Not: &eventing.SubscriptionsAPIFilter{Any: excluded}})
+		}
+		trigger.Spec.Filters = filters
+	default:
+		attributes := eventing.TriggerFilterAttributes{}
+		for attribute, value := range condition.Attributes {
+			attributes[attribute] = value
+		}
+		attributes[StepExtension] = from
+		trigger.Spec.Filter = &eventing.TriggerFilter{Attributes: attributes}
+	}
+	return trigger, nil
+}
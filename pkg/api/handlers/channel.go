@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	messaging "knative.dev/eventing/pkg/apis/messaging/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// channelTemplate builds the template for the chosen channel, nil keeps the InMemoryChannel default
+func channelTemplate(channel *model.ChannelConfig) (*messaging.ChannelTemplateSpec, error) {
+	if channel == nil {
+		return nil, nil
+	}
+	template := &messaging.ChannelTemplateSpec{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: channel.APIVersion,
+			Kind:       channel.Kind,
+		},
+	}
+	if len(channel.Spec) > 0 {
+		spec, err := json.Marshal(channel.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the %s spec: %w", channel.Kind, err)
+		}
+		template.Spec = &runtime.RawExtension{Raw: spec}
+	}
+	return template, nil
+}
+
+// validateChannel checks that the chosen channel kind is installed in the cluster
+func validateChannel(k8sClient client.Client, channel *model.ChannelConfig) []model.ValidationError {
+	if channel == nil {
+		return nil
+	}
+	invalid := func(message string) []model.ValidationError {
+		return []model.ValidationError{{Code: helpers.UnknownChannel, Message: message}}
+	}
+
+	if channel.APIVersion == "" || channel.Kind == "" {
+		return invalid("the channel needs an apiVersion and a kind")
+	}
+	gv, err := schema.ParseGroupVersion(channel.APIVersion)
+	if err != nil {
+		return invalid(fmt.Sprintf("invalid channel apiVersion %s: %v", channel.APIVersion, err))
+	}
+	_, err = k8sClient.RESTMapper().RESTMapping(gv.WithKind(channel.Kind).GroupKind(), gv.Version)
+	if meta.IsNoMatchError(err) {
+		return invalid(fmt.Sprintf("channel kind %s in %s is not installed", channel.Kind, channel.APIVersion))
+	}
+	if err != nil {
+		return invalid(fmt.Sprintf("unable to look up channel kind %s: %v", channel.Kind, err))
+	}
+	return nil
+}
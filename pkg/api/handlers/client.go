@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/config"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/kubectl/pkg/scheme"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
+	flows "knative.dev/eventing/pkg/apis/flows/v1"
+	messaging "knative.dev/eventing/pkg/apis/messaging/v1"
+	duck "knative.dev/pkg/apis/duck/v1"
+	serving "knative.dev/serving/pkg/apis/serving/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewK8sClient builds the client shared by every request, it is meant to be called once at startup
+func NewK8sClient(serverConfig *config.ServerConfigImpl) (client.WithWatch, error) {
+	cfg, err := restConfig(serverConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load cluster config: %w", err)
+	}
+	if serverConfig != nil && serverConfig.KubeQPS > 0 {
+		cfg.QPS = float32(serverConfig.KubeQPS)
+	}
+	if serverConfig != nil && serverConfig.KubeBurst > 0 {
+		cfg.Burst = serverConfig.KubeBurst
+	}
+
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		serving.AddToScheme,
+		flows.AddToScheme,
+		messaging.AddToScheme,
+		eventing.AddToScheme,
+		duck.AddToScheme,
+	} {
+		if err := addToScheme(scheme.Scheme); err != nil {
+			return nil, fmt.Errorf("unable to register schemes: %w", err)
+		}
+	}
+
+	// Create the controller-runtime client, able to watch so waits do not have to poll
+	k8sClient, err := client.NewWithWatch(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kubernetes client: %w", err)
+	}
+	return k8sClient, nil
+}
+
+// restConfig picks how to reach the cluster: the in-cluster service account when enabled,
+// then the configured kubeconfig, then the usual KUBECONFIG and ~/.kube/config lookup
+func restConfig(serverConfig *config.ServerConfigImpl) (*rest.Config, error) {
+	if serverConfig != nil && serverConfig.InCluster {
+		return rest.InClusterConfig()
+	}
+	if serverConfig != nil && serverConfig.KubeConfigUrl != "" {
+		return clientcmd.BuildConfigFromFlags("", serverConfig.KubeConfigUrl)
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"fmt"
+
+	eventingduck "knative.dev/eventing/pkg/apis/duck/v1"
+)
+
+// mergeDelivery fills the settings missing from override with the ones in base
+func mergeDelivery(base *model.DeliveryConfig, override *model.DeliveryConfig) *model.DeliveryConfig {
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+	merged := *base
+	if override.Retry != nil {
+		merged.Retry = override.Retry
+	}
+	if override.BackoffPolicy != "" {
+		merged.BackoffPolicy = override.BackoffPolicy
+	}
+	if override.BackoffDelay != "" {
+		merged.BackoffDelay = override.BackoffDelay
+	}
+	if override.Timeout != "" {
+		merged.Timeout = override.Timeout
+	}
+	if override.DeadLetterSink != nil {
+		merged.DeadLetterSink = override.DeadLetterSink
+	}
+	return &merged
+}
+
+// deliverySpec translates the delivery settings into the knative delivery spec, nil when nothing is set
+func deliverySpec(config *model.DeliveryConfig) (*eventingduck.DeliverySpec, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	spec := &eventingduck.DeliverySpec{Retry: config.Retry}
+	if config.BackoffPolicy != "" {
+		policy := eventingduck.BackoffPolicyType(config.BackoffPolicy)
+		spec.BackoffPolicy = &policy
+	}
+	if config.BackoffDelay != "" {
+		spec.BackoffDelay = &config.BackoffDelay
+	}
+	if config.Timeout != "" {
+		spec.Timeout = &config.Timeout
+	}
+	if config.DeadLetterSink != nil {
+		sink, err := destination(config.DeadLetterSink.Type, &config.DeadLetterSink.SinkConfig)
+		if err != nil {
+			return nil, fmt.Errorf("dead letter sink: %w", err)
+		}
+		spec.DeadLetterSink = sink
+	}
+
+	if equalsEmptyDelivery(spec) {
+		return nil, nil
+	}
+	return spec, nil
+}
+
+func equalsEmptyDelivery(spec *eventingduck.DeliverySpec) bool {
+	return spec.Retry == nil && spec.BackoffPolicy == nil && spec.BackoffDelay == nil &&
+		spec.Timeout == nil && spec.DeadLetterSink == nil
+}
+
+// validateDelivery checks the delivery settings of the pipeline and of every function node
+func validateDelivery(ctx context.Context, payload model.PipelinePayload) []model.ValidationError {
+	errs := []model.ValidationError{}
+	check := func(config *model.DeliveryConfig, nodeId string) {
+		spec, err := deliverySpec(config)
+		if err == nil && spec != nil {
+			if fieldErr := spec.Validate(ctx); fieldErr != nil {
+				err = fieldErr
+			}
+		}
+		if err == nil {
+			return
+		}
+		message := fmt.Sprintf("invalid delivery settings for the pipeline: %v", err)
+		if nodeId != "" {
+			message = fmt.Sprintf("invalid delivery settings for node %s: %v", nodeId, err)
+		}
+		errs = append(errs, model.ValidationError{Code: helpers.InvalidDelivery, Message: message, NodeID: nodeId})
+	}
+
+	check(payload.Delivery, "")
+	for _, node := range payload.Nodes {
+		if node.Data.Delivery != nil {
+			check(mergeDelivery(payload.Delivery, node.Data.Delivery), node.ID)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// LocalSource is the source of the event a local execution starts with, when it has none
+	LocalSource = "pipeline-api/local"
+	// LocalEventType is the type of the event a local execution starts with, when it has none
+	LocalEventType = "mocha.local"
+)
+
+var ErrNoEndpoint = errors.New("no endpoint")
+
+// LocalExecutor runs a pipeline graph in process against plain HTTP endpoints, so pipeline logic can be tried
+// in development and CI without Knative
+type LocalExecutor struct {
+	// Client resolves the node urls from the ksvc status, it can be nil when every node has an endpoint
+	Client    client.Client
+	Namespace string
+	// Endpoints maps node ids, or the faasId of a filter function, to the url called instead of the ksvc address
+	Endpoints map[string]string
+	// HTTPClient sends the events, http.DefaultClient when not set
+	HTTPClient *http.Client
+}
+
+// localRun is the state of a single execution
+type localRun struct {
+	executor   *LocalExecutor
+	graph      *Graph
+	urls       map[string]string
+	filterURLs map[string]string
+	chains     map[string][]string
+	parallels  map[string][]string
+	outgoing   map[string][]string
+	conditions map[string]map[string]*model.EdgeCondition
+	execution  *model.Execution
+}
+
+// Run sends the event to the entry node of the graph and follows the edges the way the sequences and parallels of a
+// deploy would: each chain of TraverseGraph passes the reply of every node on to the next one, a fork sends its reply
+// down every branch whose condition lets it through, and a join runs once for every event reaching it. A node that
+// fails or replies without an event ends its path. Delivery settings are not applied, nothing is retried.
+func (e *LocalExecutor) Run(ctx context.Context, payload model.PipelinePayload, event model.ExecutionEvent) (*model.Execution, error) {
+	validationErrs := helpers.ValidateGraph(payload.Nodes, payload.Edges)
+	validationErrs = append(validationErrs, helpers.ValidateRoot(payload.Nodes, payload.Edges)...)
+	if len(validationErrs) > 0 {
+		return nil, &InvalidGraphError{Message: "invalid pipeline graph", Errors: validationErrs}
+	}
+	graph, err := newGraph(payload, e.Namespace, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &localRun{
+		executor:   e,
+		graph:      graph,
+		urls:       map[string]string{},
+		filterURLs: map[string]string{},
+		chains:     map[string][]string{},
+		outgoing:   map[string][]string{},
+		conditions: branchConditions(graph.FunctionEdges),
+		execution: &model.Execution{
+			Steps:   []model.ExecutionStep{},
+			Outputs: map[string][]model.ExecutionEvent{},
+		},
+	}
+
+	// every url is resolved before the first call, a missing one should not leave the pipeline half run
+	for _, node := range graph.Functions {
+		url, err := e.endpoint(ctx, node.ID, node.Data.FaasID)
+		if err != nil {
+			return nil, err
+		}
+		run.urls[node.ID] = url
+	}
+	for _, branches := range run.conditions {
+		for _, condition := range branches {
+			if condition.FaasID == "" {
+				continue
+			}
+			url, err := e.endpoint(ctx, condition.FaasID, condition.FaasID)
+			if err != nil {
+				return nil, err
+			}
+			run.filterURLs[condition.FaasID] = url
+		}
+	}
+
+	parallels, sequences := helpers.TraverseGraph(graph.Functions, graph.FunctionEdges)
+	run.parallels = parallels
+	for _, sequence := range sequences {
+		run.chains[sequence[0]] = sequence
+	}
+	for _, edge := range graph.FunctionEdges {
+		run.outgoing[edge.Source] = append(run.outgoing[edge.Source], edge.Target)
+	}
+
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.Source == "" {
+		event.Source = LocalSource
+	}
+	if event.Type == "" {
+		event.Type = LocalEventType
+	}
+	run.deliver(ctx, graph.EntryNode, event)
+	return run.execution, nil
+}
+
+// endpoint returns the url a node or filter function is called at, its override or the address of its ksvc
+func (e *LocalExecutor) endpoint(ctx context.Context, key string, faasId string) (string, error) {
+	if url, exists := e.Endpoints[key]; exists {
+		return url, nil
+	}
+	if e.Client == nil {
+		return "", fmt.Errorf("%w for %s, set one or give the executor a client", ErrNoEndpoint, key)
+	}
+	ksvc, err := GetKsvcFromNode(e.Client, ctx, e.Namespace, &model.Node{ID: key, Data: model.NodeData{FaasID: faasId}})
+	if err != nil {
+		return "", fmt.Errorf("%s can not be mapped to a Ksvc: %w", key, err)
+	}
+	url := ksvcAddress(ksvc)
+	if url == nil {
+		return "", fmt.Errorf("%w for %s, ksvc %s has no address yet", ErrNoEndpoint, key, faasId)
+	}
+	return url.String(), nil
+}
+
+// send posts the event to the url in binary mode and returns the event it was answered with, if any
+func (e *LocalExecutor) send(ctx context.Context, url string, event model.ExecutionEvent) (*model.ExecutionEvent, int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(event.Data))
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Ce-Specversion", "1.0")
+	request.Header.Set("Ce-Id", event.ID)
+	request.Header.Set("Ce-Source", event.Source)
+	request.Header.Set("Ce-Type", event.Type)
+	if event.Subject != "" {
+		request.Header.Set("Ce-Subject", event.Subject)
+	}
+	for name, value := range event.Extensions {
+		request.Header.Set("Ce-"+name, value)
+	}
+	if event.ContentType != "" {
+		request.Header.Set("Content-Type", event.ContentType)
+	}
+
+	httpClient := e.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, response.StatusCode, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, response.StatusCode, fmt.Errorf("replied with %s", response.Status)
+	}
+	reply, err := replyEvent(event, response.Header, body)
+	if err != nil {
+		return nil, response.StatusCode, err
+	}
+	return reply, response.StatusCode, nil
+}
+
+// deliver hands an event to a node the way a reply reaches it, sinks keep it and anything else runs its chain
+func (r *localRun) deliver(ctx context.Context, nodeId string, event model.ExecutionEvent) {
+	if _, isSink := r.graph.Sinks[nodeId]; isSink {
+		r.output(nodeId, event)
+		return
+	}
+	chain := r.chains[nodeId]
+	if len(chain) == 0 {
+		return
+	}
+	for _, step := range chain {
+		if _, isSink := r.graph.Sinks[step]; isSink {
+			r.output(step, event)
+			return
+		}
+		reply := r.call(ctx, model.ExecutionStep{NodeID: step, URL: r.urls[step], Input: event})
+		if reply == nil {
+			return
+		}
+		event = *reply
+	}
+
+	last := chain[len(chain)-1]
+	if branches, isFork := r.parallels[last]; isFork {
+		for _, branch := range branches {
+			if filtered := r.filter(ctx, last, branch, event); filtered != nil {
+				r.deliver(ctx, branch, *filtered)
+			}
+		}
+		return
+	}
+	if next := r.outgoing[last]; len(next) == 1 {
+		r.deliver(ctx, next[0], event)
+		return
+	}
+	r.output(last, event)
+}
+
+// filter decides whether the reply of a fork goes down a branch, as the branch filter of the parallel would. Attribute
+// conditions are matched here instead of by the filter service, and a filter function passes on the event it replies with.
+func (r *localRun) filter(ctx context.Context, fork string, branch string, event model.ExecutionEvent) *model.ExecutionEvent {
+	conditions := r.conditions[fork]
+	condition := conditions[branch]
+	switch {
+	case condition == nil:
+		return &event
+	case condition.FaasID != "":
+		return r.call(ctx, model.ExecutionStep{NodeID: branch, Filter: condition.FaasID, URL: r.filterURLs[condition.FaasID], Input: event})
+	case condition.Otherwise:
+		for _, other := range conditions {
+			if len(other.Attributes) > 0 && matchesAttributes(other.Attributes, event) {
+				return nil
+			}
+		}
+		return &event
+	}
+	if matchesAttributes(condition.Attributes, event) {
+		return &event
+	}
+	return nil
+}
+
+// call makes the call of a step and records it, returning the event replied with
+func (r *localRun) call(ctx context.Context, step model.ExecutionStep) *model.ExecutionEvent {
+	reply, status, err := r.executor.send(ctx, step.URL, step.Input)
+	step.Output = reply
+	step.Status = status
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.execution.Steps = append(r.execution.Steps, step)
+	return reply
+}
+
+// output records an event leaving the pipeline through a node
+func (r *localRun) output(nodeId string, event model.ExecutionEvent) {
+	r.execution.Outputs[nodeId] = append(r.execution.Outputs[nodeId], event)
+}
+
+// matchesAttributes reports whether the event has every attribute with the given value
+func matchesAttributes(attributes map[string]string, event model.ExecutionEvent) bool {
+	for name, value := range attributes {
+		if eventAttribute(event, name) != value {
+			return false
+		}
+	}
+	return true
+}
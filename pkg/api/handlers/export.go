@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	serving "knative.dev/serving/pkg/apis/serving/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Modes the tasks of an exported workflow run the functions in
+const (
+	// WorkflowHTTPMode posts the task data to the address of every function, the default
+	WorkflowHTTPMode = "http"
+	// WorkflowContainerMode runs the image of every function with the task data in its environment
+	WorkflowContainerMode = "container"
+	// DataEnv is the environment variable holding the task data in container mode
+	DataEnv = "PIPELINE_DATA"
+)
+
+const (
+	// workflowEntrypoint is the template holding the DAG
+	workflowEntrypoint = "pipeline"
+	// dataParameter carries the data into every task, it is also the argument the workflow is submitted with
+	dataParameter = "data"
+)
+
+var (
+	ErrUnknownWorkflowMode   = errors.New("unknown workflow mode")
+	ErrWorkflowsNotInstalled = errors.New("argo workflows is not installed")
+)
+
+var workflowTemplateKind = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "WorkflowTemplate"}
+
+// workflowTranslator turns the graph into an argo WorkflowTemplate. It backs the export rather than a deploy backend,
+// a template runs when it is submitted instead of on the events reaching the pipeline.
+type workflowTranslator struct {
+	// Mode is how the tasks run the functions, WorkflowHTTPMode or WorkflowContainerMode
+	Mode string
+}
+
+// Validate rejects the edge conditions, a DAG task runs once all the tasks before it are done whatever they returned
+func (t workflowTranslator) Validate(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload) []model.ValidationError {
+	validationErrs := []model.ValidationError{}
+	for _, edge := range payload.Edges {
+		if edge.Condition != nil {
+			validationErrs = append(validationErrs, model.ValidationError{
+				Code:    helpers.InvalidCondition,
+				Message: fmt.Sprintf("edge %s has a condition, conditions can not be exported to a workflow", edge.ID),
+				EdgeID:  edge.ID,
+			})
+		}
+	}
+	return validationErrs
+}
+
+func (t workflowTranslator) Kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{workflowTemplateKind}
+}
+
+// Translate returns a WorkflowTemplate running a DAG task per function, each depending on the tasks of the edges
+// into it. Source and sink nodes are left out, the workflow starts when it is submitted and its result stays with it.
+func (t workflowTranslator) Translate(ctx context.Context, k8sClient client.Client, graph *Graph) ([]client.Object, error) {
+	// the tasks every function waits for, edges into sinks go with the sinks
+	dependencies := map[string][]string{}
+	for _, edge := range graph.FunctionEdges {
+		if _, isSink := graph.Sinks[edge.Target]; isSink {
+			continue
+		}
+		dependencies[edge.Target] = append(dependencies[edge.Target], edge.Source)
+	}
+
+	tasks := []interface{}{}
+	templates := []interface{}{}
+	for _, node := range graph.Functions {
+		ksvc, err := GetKsvcFromNode(k8sClient, ctx, graph.Namespace, &node)
+		if err != nil {
+			return nil, fmt.Errorf("node %s can not be mapped to a Ksvc: %w", node.ID, err)
+		}
+		template, err := taskTemplate(helpers.TaskName(node.ID), ksvc, t.Mode)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+		tasks = append(tasks, dagTask(node.ID, dependencies[node.ID]))
+	}
+	dag := map[string]interface{}{
+		"name": workflowEntrypoint,
+		"dag":  map[string]interface{}{"tasks": tasks},
+	}
+
+	workflow := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"entrypoint": workflowEntrypoint,
+			"arguments": map[string]interface{}{
+				"parameters": []interface{}{
+					map[string]interface{}{"name": dataParameter, "value": "{}"},
+				},
+			},
+			"templates": append([]interface{}{dag}, templates...),
+		},
+	}}
+	workflow.SetGroupVersionKind(workflowTemplateKind)
+	workflow.SetName(graph.PipelineID)
+	workflow.SetNamespace(graph.Namespace)
+	return []client.Object{workflow}, nil
+}
+
+// ExportWorkflow translates the graph into an argo WorkflowTemplate through the workflow translator, labelled with
+// the pipeline it was exported from
+func ExportWorkflow(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload, namespace string, mode string) (*model.WorkflowExport, error) {
+	if mode == "" {
+		mode = WorkflowHTTPMode
+	}
+	if mode != WorkflowHTTPMode && mode != WorkflowContainerMode {
+		return nil, fmt.Errorf("%w %s, use %s or %s", ErrUnknownWorkflowMode, mode, WorkflowHTTPMode, WorkflowContainerMode)
+	}
+	translator := workflowTranslator{Mode: mode}
+	payload = applyDefaults(ctx, payload)
+
+	validationErrs := helpers.ValidateGraph(payload.Nodes, payload.Edges)
+	validationErrs = append(validationErrs, translator.Validate(ctx, k8sClient, payload)...)
+	if len(validationErrs) > 0 {
+		return nil, &InvalidGraphError{Message: "invalid pipeline graph", Errors: validationErrs}
+	}
+
+	pipelineId := helpers.PipelineID(payload.Name, payload.Nodes, payload.Edges)
+	graph, err := newGraph(payload, namespace, pipelineId, nil)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := translator.Translate(ctx, k8sClient, graph)
+	if err != nil {
+		return nil, err
+	}
+	workflow, err := toUnstructured(k8sClient, objects[0])
+	if err != nil {
+		return nil, err
+	}
+	workflow.SetLabels(map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy})
+
+	return &model.WorkflowExport{ID: pipelineId, Mode: mode, Workflow: *workflow}, nil
+}
+
+// ApplyWorkflow creates the exported workflow, or updates it when it was applied before, and records the request on it.
+// The workflow is owned by the graph of its pipeline when the pipeline is deployed, so it goes along with it.
+func ApplyWorkflow(ctx context.Context, k8sClient client.Client, workflow *unstructured.Unstructured) error {
+	gvk := workflow.GroupVersionKind()
+	_, err := k8sClient.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("%w, %s %s can not be applied", ErrWorkflowsNotInstalled, gvk.Kind, workflow.GetName())
+	}
+	if err != nil {
+		return err
+	}
+
+	info := requestInfoFrom(ctx)
+	annotations := map[string]string{}
+	if info.ID != "" {
+		annotations[RequestIdAnnotation] = info.ID
+	}
+	if info.User != "" {
+		annotations[CreatedByAnnotation] = info.User
+	}
+
+	graph := &corev1.ConfigMap{}
+	err = k8sClient.Get(ctx, types.NamespacedName{Name: workflow.GetLabels()[PipelineIdLabel], Namespace: workflow.GetNamespace()}, graph)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && graph.Labels[PipelineIdLabel] == graph.Name {
+		workflow.SetOwnerReferences(graphOwnerReferences(graph))
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(gvk)
+	err = k8sClient.Get(ctx, client.ObjectKeyFromObject(workflow), current)
+	if apierrors.IsNotFound(err) {
+		workflow.SetAnnotations(annotations)
+		return k8sClient.Create(ctx, workflow)
+	}
+	if err != nil {
+		return err
+	}
+	keepProvenance(current.GetAnnotations(), annotations)
+	workflow.SetAnnotations(annotations)
+	workflow.SetResourceVersion(current.GetResourceVersion())
+	return k8sClient.Update(ctx, workflow)
+}
+
+// dagTask runs the template of a node once the tasks before it are done. Root tasks get the data the workflow was
+// submitted with, the others the result of the task before them, or a JSON array of the results where tasks join.
+func dagTask(nodeId string, dependsOn []string) map[string]interface{} {
+	name := helpers.TaskName(nodeId)
+	task := map[string]interface{}{"name": name, "template": name}
+
+	data := "{{workflow.parameters." + dataParameter + "}}"
+	if len(dependsOn) > 0 {
+		dependencies := []interface{}{}
+		results := []string{}
+		for _, dependency := range dependsOn {
+			dependencyName := helpers.TaskName(dependency)
+			dependencies = append(dependencies, dependencyName)
+			results = append(results, "{{tasks."+dependencyName+".outputs.result}}")
+		}
+		task["dependencies"] = dependencies
+		data = results[0]
+		if len(results) > 1 {
+			data = "[" + strings.Join(results, ",") + "]"
+		}
+	}
+	task["arguments"] = map[string]interface{}{
+		"parameters": []interface{}{
+			map[string]interface{}{"name": dataParameter, "value": data},
+		},
+	}
+	return task
+}
+
+// taskTemplate runs the function behind a ksvc, either by posting the task data to its address or by running its image
+func taskTemplate(name string, ksvc *serving.Service, mode string) (map[string]interface{}, error) {
+	template := map[string]interface{}{
+		"name": name,
+		"inputs": map[string]interface{}{
+			"parameters": []interface{}{
+				map[string]interface{}{"name": dataParameter},
+			},
+		},
+	}
+	data := "{{inputs.parameters." + dataParameter + "}}"
+
+	if mode == WorkflowContainerMode {
+		containers := ksvc.Spec.Template.Spec.Containers
+		if len(containers) == 0 || containers[0].Image == "" {
+			return nil, fmt.Errorf("ksvc %s has no image to run", ksvc.Name)
+		}
+		function := containers[0].DeepCopy()
+		container := corev1.Container{
+			Name:    "main",
+			Image:   function.Image,
+			Command: function.Command,
+			Args:    function.Args,
+			Env:     append(function.Env, corev1.EnvVar{Name: DataEnv, Value: data}),
+		}
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&container)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert the container of ksvc %s: %w", ksvc.Name, err)
+		}
+		template["container"] = object
+		return template, nil
+	}
+
+	url := ksvcAddress(ksvc)
+	if url == nil {
+		return nil, fmt.Errorf("ksvc %s has no address yet", ksvc.Name)
+	}
+	template["http"] = map[string]interface{}{
+		"url":    url.String(),
+		"method": "POST",
+		"headers": []interface{}{
+			map[string]interface{}{"name": "Content-Type", "value": "application/json"},
+		},
+		"body": data,
+	}
+	return template, nil
+}
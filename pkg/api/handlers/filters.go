@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	duck "knative.dev/pkg/apis/duck/v1"
+	serving "knative.dev/serving/pkg/apis/serving/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExcludeParam is the query parameter the filter service receives, once per attribute condition, on an otherwise branch
+const ExcludeParam = "exclude"
+
+// structuredContentType marks a CloudEvent sent in structured mode, attributes and data in one JSON body
+const structuredContentType = "application/cloudevents+json"
+
+// FilterHandler is the filter service attribute conditions are evaluated by, as the filter of their parallel branch.
+// Every query parameter other than exclude names a CloudEvent attribute, extensions included, and the value the event
+// must have for it. Every exclude parameter is such a set of attributes encoded as a query, and drops the events
+// matching all of them. An event that passes is replied with as it came in, one that does not gets an empty 202 reply,
+// which ends its way down the branch. Events that are not CloudEvents are answered with 400.
+type FilterHandler struct{}
+
+func (FilterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event, err := requestEvent(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !passesFilter(r.URL.Query(), *event) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// the reply is the event itself, in the mode it came in
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "ce-") || strings.EqualFold(name, "Content-Type") {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// requestEvent reads the CloudEvent of a request, in binary or structured mode
+func requestEvent(header http.Header, body []byte) (*model.ExecutionEvent, error) {
+	if strings.HasPrefix(header.Get("Content-Type"), structuredContentType) {
+		return structuredEvent(body)
+	}
+	if header.Get("Ce-Id") == "" {
+		return nil, fmt.Errorf("the request is not a CloudEvent")
+	}
+	return replyEvent(model.ExecutionEvent{}, header, body)
+}
+
+// structuredEvent reads a CloudEvent in structured mode, its data is kept as the JSON it was sent as unless it is a string
+func structuredEvent(body []byte) (*model.ExecutionEvent, error) {
+	attributes := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid structured CloudEvent: %w", err)
+	}
+	event := &model.ExecutionEvent{Extensions: map[string]string{}}
+	for name, value := range attributes {
+		var text string
+		isText := json.Unmarshal(value, &text) == nil
+		switch name {
+		case "data":
+			event.Data = string(value)
+			if isText {
+				event.Data = text
+			}
+			continue
+		case "data_base64":
+			data, err := base64.StdEncoding.DecodeString(text)
+			if !isText || err != nil {
+				return nil, fmt.Errorf("invalid structured CloudEvent: data_base64 is not base64")
+			}
+			event.Data = string(data)
+			continue
+		}
+		if !isText {
+			continue
+		}
+		switch name {
+		case "specversion":
+		case "id":
+			event.ID = text
+		case "source":
+			event.Source = text
+		case "type":
+			event.Type = text
+		case "subject":
+			event.Subject = text
+		case "datacontenttype":
+			event.ContentType = text
+		default:
+			event.Extensions[name] = text
+		}
+	}
+	if event.ID == "" {
+		return nil, fmt.Errorf("the request is not a CloudEvent")
+	}
+	return event, nil
+}
+
+// replyEvent reads the event of a reply, in binary or structured mode. A reply with a body but no CloudEvent headers
+// keeps the attributes of the event it answers under a new id, an empty one has no event.
+func replyEvent(request model.ExecutionEvent, header http.Header, body []byte) (*model.ExecutionEvent, error) {
+	if len(body) == 0 && header.Get("Ce-Id") == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), structuredContentType) {
+		return structuredEvent(body)
+	}
+	if header.Get("Ce-Id") == "" {
+		reply := request
+		reply.ID = uuid.NewString()
+		reply.ContentType = header.Get("Content-Type")
+		reply.Data = string(body)
+		return &reply, nil
+	}
+
+	reply := &model.ExecutionEvent{
+		ContentType: header.Get("Content-Type"),
+		Extensions:  map[string]string{},
+		Data:        string(body),
+	}
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "ce-") || len(values) == 0 {
+			continue
+		}
+		switch attribute := strings.TrimPrefix(name, "ce-"); attribute {
+		case "specversion":
+		case "id":
+			reply.ID = values[0]
+		case "source":
+			reply.Source = values[0]
+		case "type":
+			reply.Type = values[0]
+		case "subject":
+			reply.Subject = values[0]
+		default:
+			reply.Extensions[attribute] = values[0]
+		}
+	}
+	return reply, nil
+}
+
+// passesFilter reports whether the event has every attribute of the query and does not match any it excludes
+func passesFilter(query url.Values, event model.ExecutionEvent) bool {
+	for _, excluded := range query[ExcludeParam] {
+		attributes, err := url.ParseQuery(excluded)
+		if err == nil && len(attributes) > 0 && matchesQuery(attributes, event) {
+			return false
+		}
+	}
+	return matchesQuery(query, event)
+}
+
+// matchesQuery reports whether the event has every attribute of the query, exclude is not an attribute
+func matchesQuery(query url.Values, event model.ExecutionEvent) bool {
+	for name, values := range query {
+		if name == ExcludeParam {
+			continue
+		}
+		for _, value := range values {
+			if eventAttribute(event, name) != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// eventAttribute returns a CloudEvent attribute of the event by name, extensions included
+func eventAttribute(event model.ExecutionEvent, name string) string {
+	switch name {
+	case "specversion":
+		return "1.0"
+	case "id":
+		return event.ID
+	case "source":
+		return event.Source
+	case "type":
+		return event.Type
+	case "subject":
+		return event.Subject
+	case "datacontenttype":
+		return event.ContentType
+	}
+	return event.Extensions[name]
+}
+
+// validateFilters checks that attribute conditions have a filter service to evaluate them
+func validateFilters(payload model.PipelinePayload) []model.ValidationError {
+	errs := []model.ValidationError{}
+	for _, edge := range payload.Edges {
+		condition := edge.Condition
+		if condition == nil || condition.FaasID != "" {
+			continue
+		}
+		if payload.FilterURI == "" {
+			errs = append(errs, model.ValidationError{
+				Code:    helpers.InvalidCondition,
+				Message: fmt.Sprintf("condition on edge %s needs a filter service, none is configured", edge.ID),
+				EdgeID:  edge.ID,
+			})
+		}
+	}
+	return errs
+}
+
+// branchConditions indexes the conditions on the edges leaving each fork by the branch they lead to
+func branchConditions(edges []model.Edge) map[string]map[string]*model.EdgeCondition {
+	conditions := map[string]map[string]*model.EdgeCondition{}
+	for _, edge := range edges {
+		if edge.Condition == nil {
+			continue
+		}
+		if conditions[edge.Source] == nil {
+			conditions[edge.Source] = map[string]*model.EdgeCondition{}
+		}
+		conditions[edge.Source][edge.Target] = edge.Condition
+	}
+	return conditions
+}
+
+// branchFilter translates the condition of a branch into its filter, nil lets every event through.
+// Attribute conditions are sent to the filter service as query parameters, an otherwise branch
+// excludes the attribute conditions of every other branch of the fork.
+func branchFilter(ctx context.Context, k8sClient client.Client, namespace string, filterURI string, branch string, conditions map[string]*model.EdgeCondition) (*duck.Destination, error) {
+	condition := conditions[branch]
+	if condition == nil {
+		return nil, nil
+	}
+
+	if condition.FaasID != "" {
+		ksvc := &serving.Service{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: condition.FaasID, Namespace: namespace}, ksvc); err != nil {
+			fmt.Println("Ksvc not found: ", err)
+			return nil, fmt.Errorf("Filter can not be mapped to a Ksvc: " + condition.FaasID)
+		}
+		return &duck.Destination{
+			Ref: &duck.KReference{
+				APIVersion: "serving.knative.dev/v1",
+				Kind:       "Service",
+				Name:       condition.FaasID,
+			},
+		}, nil
+	}
+
+	uri, err := apis.ParseURL(filterURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter uri: %w", err)
+	}
+	query := uri.URL().Query()
+	if condition.Otherwise {
+		for other, otherCondition := range conditions {
+			if other != branch && len(otherCondition.Attributes) > 0 {
+				query.Add(ExcludeParam, attributeQuery(otherCondition.Attributes).Encode())
+			}
+		}
+	} else {
+		for key, values := range attributeQuery(condition.Attributes) {
+			query[key] = append(query[key], values...)
+		}
+	}
+	// keep the filter stable from one plan to the next, the other branches come out of a map
+	sort.Strings(query[ExcludeParam])
+	uri.RawQuery = query.Encode()
+	return &duck.Destination{URI: uri}, nil
+}
+
+func attributeQuery(attributes map[string]string) url.Values {
+	query := url.Values{}
+	for attribute, value := range attributes {
+		query.Set(attribute, value)
+	}
+	return query
+}
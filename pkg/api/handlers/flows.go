@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	duck "knative.dev/pkg/apis/duck/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// flowsTranslator chains the functions with knative sequences and parallels, it is the default backend
+type flowsTranslator struct{}
+
+// Validate checks that the chosen channel is installed and that attribute conditions can be evaluated
+func (flowsTranslator) Validate(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload) []model.ValidationError {
+	validationErrs := validateChannel(k8sClient, payload.Channel)
+	return append(validationErrs, validateFilters(payload)...)
+}
+
+// Kinds are the sequences and parallels along with the sources
+func (flowsTranslator) Kinds() []schema.GroupVersionKind {
+	kinds := []schema.GroupVersionKind{
+		sequenceKind,
+		parallelKind,
+	}
+	return append(kinds, sourceGVKs()...)
+}
+
+// Translate works out the sequences and parallels of the graph. Sequences and parallels are matched to the deployed
+// ones by the node they start from, so those keep their names, new ones are named after the pipeline and the nodes they cover.
+func (flowsTranslator) Translate(ctx context.Context, k8sClient client.Client, graph *Graph) ([]client.Object, error) {
+	payload, namespace, pipelineId := graph.PipelinePayload, graph.Namespace, graph.PipelineID
+	sourceTargets, sinks, entryNode := graph.SourceTargets, graph.Sinks, graph.EntryNode
+
+	// index what is already deployed by the node each resource starts from
+	existingSequences := map[string]string{}
+	for _, sequence := range graph.Existing.Sequences {
+		nodeIds := strings.Split(sequence.Annotations[NodesAnnotation], ",")
+		existingSequences[nodeIds[0]] = sequence.Name
+	}
+	existingParallels := map[string]string{}
+	for _, parallel := range graph.Existing.Parallels {
+		existingParallels[parallel.Annotations[ForkNodeAnnotation]] = parallel.Name
+	}
+
+	// identify the parallels and sequences
+	// sources and sinks are kept in the traversal so the node a source points at always starts a sequence, then dropped
+	parallels, sequences := helpers.TraverseGraph(
+		payload.Nodes, payload.Edges)
+	sequences = withoutEndpoints(sequences, sourceTargets, sinks)
+
+	// names are settled up front since sequences reply into parallels and parallels fan out to sequences
+	sequenceNames := map[string]string{}
+	for _, sequence := range sequences {
+		sequenceNames[sequence[0]] = helpers.ResourceName(pipelineId, "sequence", sequence...)
+		if name, exists := existingSequences[sequence[0]]; exists {
+			sequenceNames[sequence[0]] = name
+		}
+		// update the first node to set its sequence id
+		updateNode(payload.Nodes, sequence[0], sequenceNames[sequence[0]])
+	}
+	parallelNames := map[string]string{}
+	for forkNode := range parallels {
+		parallelNames[forkNode] = helpers.ResourceName(pipelineId, "parallel", forkNode)
+		if name, exists := existingParallels[forkNode]; exists {
+			parallelNames[forkNode] = name
+		}
+	}
+
+	outgoingNeighbors := map[string][]string{}
+	for _, edge := range payload.Edges {
+		outgoingNeighbors[edge.Source] = append(outgoingNeighbors[edge.Source], edge.Target)
+	}
+
+	channel, err := channelTemplate(payload.Channel)
+	if err != nil {
+		return nil, err
+	}
+	objects := []client.Object{}
+
+	// handle sequences
+	// for each sequence in the sequences list, construct the knative sequence
+	for _, sequence := range sequences {
+
+		// return a list of faas ids if the sequence is valid
+		validNodes, err := GetValidNodes(ctx, k8sClient, namespace, sequence, payload.Nodes)
+		if err != nil {
+			fmt.Println("Unable to validate nodes: ", err)
+			return nil, err
+		}
+
+		// with the valid nodes, construct our sequence
+		ksequence := TranslateSequence(validNodes, namespace, sequenceNames[sequence[0]])
+		if channel != nil {
+			ksequence.Spec.ChannelTemplate = channel.DeepCopy()
+		}
+		for i, nodeId := range sequence {
+			node, _ := GetNodeByID(payload.Nodes, nodeId)
+			delivery, err := deliverySpec(mergeDelivery(payload.Delivery, node.Data.Delivery))
+			if err != nil {
+				return nil, err
+			}
+			ksequence.Spec.Steps[i].Delivery = delivery
+		}
+		ksequence.Spec.Reply = sequenceReply(sequence[len(sequence)-1], outgoingNeighbors, sequenceNames, parallelNames, sinks)
+		ksequence.Labels = map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy}
+		ksequence.Annotations = map[string]string{NodesAnnotation: strings.Join(sequence, ",")}
+		// events enter the pipeline through the sequence of the root node
+		if sequence[0] == entryNode {
+			ksequence.Annotations[EntryAnnotation] = "true"
+		}
+		objects = append(objects, &ksequence)
+	}
+
+	// handle parallels
+	// each branch subscribes the sequence of its first node, which replies onwards by itself
+	branchDelivery, err := deliverySpec(payload.Delivery)
+	if err != nil {
+		return nil, err
+	}
+	conditions := branchConditions(payload.Edges)
+	// map order is random, sorting keeps the objects in the same order for every translation of the graph
+	forkNodes := make([]string, 0, len(parallels))
+	for forkNode := range parallels {
+		forkNodes = append(forkNodes, forkNode)
+	}
+	sort.Strings(forkNodes)
+	for _, forkNode := range forkNodes {
+		branches := parallels[forkNode]
+		kparallel := TranslateParallel(branches, namespace, parallelNames[forkNode], payload.Nodes)
+		if channel != nil {
+			kparallel.Spec.ChannelTemplate = channel.DeepCopy()
+		}
+		for i, nodeId := range branches {
+			kparallel.Spec.Branches[i].Delivery = branchDelivery.DeepCopy()
+			filter, err := branchFilter(ctx, k8sClient, namespace, payload.FilterURI, nodeId, conditions[forkNode])
+			if err != nil {
+				return nil, err
+			}
+			kparallel.Spec.Branches[i].Filter = filter
+			// a branch going straight to a sink has no sequence, the sink subscribes to the parallel itself
+			if sink, isSink := sinks[nodeId]; isSink {
+				kparallel.Spec.Branches[i].Subscriber = *sink.DeepCopy()
+			}
+		}
+		kparallel.Labels = map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy}
+		kparallel.Annotations = map[string]string{ForkNodeAnnotation: forkNode}
+		objects = append(objects, &kparallel)
+	}
+
+	// handle sources
+	sources, err := translateSources(payload, namespace, pipelineId, sourceTargets, func(target string) duck.Destination {
+		return duck.Destination{
+			Ref: &duck.KReference{
+				APIVersion: "flows.knative.dev/v1",
+				Kind:       "Sequence",
+				Name:       sequenceNames[target],
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range sources {
+		objects = append(objects, &sources[i])
+	}
+	return objects, nil
+}
@@ -4,26 +4,86 @@ import (
 	"aaaas/pipeline-api/pkg/api/helpers"
 	"aaaas/pipeline-api/pkg/api/model"
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/http"
-	"strconv"
 
 	"github.com/pcs-aa-aas/commons/pkg/api/server"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/kubectl/pkg/scheme"
 	flows "knative.dev/eventing/pkg/apis/flows/v1"
 	messaging "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/apis"
 	duck "knative.dev/pkg/apis/duck/v1"
 	serving "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-type HandlerGroup struct{}
+// HandlerGroup serves the pipeline api with a client shared across requests
+type HandlerGroup struct {
+	Client client.WithWatch
+	// Delivery holds the server wide delivery defaults, pipelines and nodes override them
+	Delivery *model.DeliveryConfig
+	// Channel is the channel used by pipelines that do not pick their own
+	Channel *model.ChannelConfig
+	// FilterURI is the service evaluating attribute conditions for pipelines that do not name their own
+	FilterURI string
+	// StepURI is the service relaying calls to functions with the broker backend, for pipelines that do not name their own
+	StepURI string
+	// Backend is the translator used by pipelines that do not pick their own
+	Backend string
+}
+
+type HandlerOption func(*HandlerGroup)
+
+// WithDeliveryDefaults applies the delivery settings to every pipeline that does not set its own
+func WithDeliveryDefaults(delivery *model.DeliveryConfig) HandlerOption {
+	return func(h *HandlerGroup) {
+		h.Delivery = delivery
+	}
+}
+
+// WithChannelDefaults sets the channel used by every pipeline that does not pick its own
+func WithChannelDefaults(channel *model.ChannelConfig) HandlerOption {
+	return func(h *HandlerGroup) {
+		h.Channel = channel
+	}
+}
+
+// WithFilterURI sets the service evaluating the attribute conditions on fork branches
+func WithFilterURI(filterURI string) HandlerOption {
+	return func(h *HandlerGroup) {
+		h.FilterURI = filterURI
+	}
+}
+
+// WithStepURI sets the service relaying the calls to functions with the broker backend
+func WithStepURI(stepURI string) HandlerOption {
+	return func(h *HandlerGroup) {
+		h.StepURI = stepURI
+	}
+}
+
+// WithBackend sets the backend used by every pipeline that does not pick its own
+func WithBackend(backend string) HandlerOption {
+	return func(h *HandlerGroup) {
+		h.Backend = backend
+	}
+}
+
+func NewHandlerGroup(k8sClient client.WithWatch, opts ...HandlerOption) HandlerGroup {
+	h := HandlerGroup{Client: k8sClient}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	return h
+}
 
 func (h HandlerGroup) GroupPath() string {
 	return "v1"
@@ -36,29 +96,403 @@ func (h HandlerGroup) HandlerManifests() []server.APIHandlerManifest {
 			HTTPMethod:  http.MethodPost,
 			HandlerFunc: h.addPipeline,
 		},
+		{
+			Path:        "pipeline/preview",
+			HTTPMethod:  http.MethodPost,
+			HandlerFunc: h.previewPipeline,
+		},
+		{
+			Path:        "pipeline/export",
+			HTTPMethod:  http.MethodPost,
+			HandlerFunc: h.exportPipeline,
+		},
+		{
+			Path:        "pipelines",
+			HTTPMethod:  http.MethodGet,
+			HandlerFunc: h.listPipelines,
+		},
+		{
+			Path:        "pipelines/:id",
+			HTTPMethod:  http.MethodGet,
+			HandlerFunc: h.getPipeline,
+		},
+		{
+			Path:        "pipelines/:id/status",
+			HTTPMethod:  http.MethodGet,
+			HandlerFunc: h.getPipelineStatus,
+		},
+		{
+			Path:        "pipelines/:id",
+			HTTPMethod:  http.MethodPut,
+			HandlerFunc: h.updatePipeline,
+		},
+		{
+			Path:        "pipelines/:id",
+			HTTPMethod:  http.MethodDelete,
+			HandlerFunc: h.deletePipeline,
+		},
 	}
 }
 
+// PipelineDefaults are the settings a payload falls back to for the ones it leaves out
+type PipelineDefaults struct {
+	Delivery  *model.DeliveryConfig
+	Channel   *model.ChannelConfig
+	FilterURI string
+	StepURI   string
+	Backend   string
+}
+
+type defaultsKey struct{}
+
+// WithDefaults attaches the defaults to the context, they are filled in when the graph is planned or translated so the
+// graph is stored as it was submitted
+func WithDefaults(ctx context.Context, defaults PipelineDefaults) context.Context {
+	return context.WithValue(ctx, defaultsKey{}, defaults)
+}
+
+// defaults are the settings the handlers were configured with
+func (k *HandlerGroup) defaults() PipelineDefaults {
+	return PipelineDefaults{Delivery: k.Delivery, Channel: k.Channel, FilterURI: k.FilterURI, StepURI: k.StepURI, Backend: k.Backend}
+}
+
+// applyDefaults returns the payload with the settings it leaves out filled in from the defaults in the context
+func applyDefaults(ctx context.Context, payload model.PipelinePayload) model.PipelinePayload {
+	defaults, _ := ctx.Value(defaultsKey{}).(PipelineDefaults)
+	payload.Delivery = mergeDelivery(defaults.Delivery, payload.Delivery)
+	if payload.Channel == nil {
+		payload.Channel = defaults.Channel
+	}
+	if payload.FilterURI == "" {
+		payload.FilterURI = defaults.FilterURI
+	}
+	if payload.StepURI == "" {
+		payload.StepURI = defaults.StepURI
+	}
+	if payload.Backend == "" {
+		payload.Backend = defaults.Backend
+	}
+	return payload
+}
+
 func (k *HandlerGroup) addPipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
-	var payload model.PipelinePayload
-	namespace := "default"
+	if c.Query("dryRun") == "true" {
+		return k.previewPipeline(s, c)
+	}
 
-	//TODO don't hardcode this
-	k8sClient := getK8sClient()
+	var payload model.PipelinePayload
+	k8sClient := k.Client
 
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		return http.StatusBadRequest, err
 	}
+	namespace, err := requestNamespace(c, &payload)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	timeout, err := parseWait(c.Query("wait"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "create"); err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckServiceAccounts(c, k8sClient, namespace, info, payload); err != nil {
+		return namespaceStatus(err), err
+	}
 
 	// call func to do each step
-	err := ProcessPayload(k8sClient, c, payload, namespace)
+	ctx := WithDefaults(WithRequestInfo(c, info), k.defaults())
+	pipeline, err := ProcessPayload(k8sClient, ctx, payload, namespace)
+	if errors.Is(err, ErrPipelineExists) {
+		return http.StatusConflict, err
+	}
+
+	var invalidErr *InvalidGraphError
+	if errors.As(err, &invalidErr) {
+		return http.StatusBadRequest, invalidErr
+	}
+	var deployErr *DeployError
+	if errors.As(err, &deployErr) {
+		return http.StatusBadRequest, deployErr
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	response := map[string]interface{}{
+		"message":   "success",
+		"id":        pipeline.ID,
+		"entry":     pipeline.Entry,
+		"resources": PipelineResourceNames(pipeline),
+	}
+	if timeout == 0 {
+		// the address is only there if knative was quick, callers can wait or poll the status for it
+		if err := refreshEntry(c, k8sClient, namespace, pipeline); err != nil {
+			fmt.Println("Unable to refresh pipeline entry: ", err)
+		}
+		response["entry"] = pipeline.Entry
+		return http.StatusOK, response
+	}
+
+	// the caller asked to hold the response until the pipeline can take events
+	status, err := WaitForPipeline(c, k8sClient, namespace, pipeline.ID, timeout)
+	var notReadyErr *NotReadyError
+	if errors.As(err, &notReadyErr) {
+		return http.StatusGatewayTimeout, notReadyErr
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	response["entry"] = status.Entry
+	response["status"] = status
+	return http.StatusOK, response
+}
+
+func (k *HandlerGroup) previewPipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	var payload model.PipelinePayload
+	k8sClient := k.Client
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		return http.StatusBadRequest, err
+	}
+	namespace, err := requestNamespace(c, &payload)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "create"); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	preview, err := PreviewPipeline(WithDefaults(c, k.defaults()), k8sClient, payload, namespace, c.Query("serverDryRun") == "true")
+	var invalidErr *InvalidGraphError
+	if errors.As(err, &invalidErr) {
+		return http.StatusBadRequest, invalidErr
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if c.Query("format") == "yaml" {
+		preview.Manifests, err = RenderManifests(&preview.Pipeline)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	return http.StatusOK, preview
+}
+
+func (k *HandlerGroup) exportPipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	var payload model.PipelinePayload
+	k8sClient := k.Client
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		return http.StatusBadRequest, err
+	}
+	namespace, err := requestNamespace(c, &payload)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	// applying creates a workflow template, which the caller has to be allowed as well
+	extraKinds := []schema.GroupVersionKind{}
+	if c.Query("apply") == "true" {
+		extraKinds = append(extraKinds, workflowTemplateKind)
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "create", extraKinds...); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	export, err := ExportWorkflow(WithDefaults(c, k.defaults()), k8sClient, payload, namespace, c.Query("mode"))
+	var invalidErr *InvalidGraphError
+	if errors.As(err, &invalidErr) {
+		return http.StatusBadRequest, invalidErr
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// applying is optional, the export is usable as it is with any argo install
+	if c.Query("apply") == "true" {
+		err := ApplyWorkflow(WithRequestInfo(c, info), k8sClient, &export.Workflow)
+		if errors.Is(err, ErrWorkflowsNotInstalled) {
+			return http.StatusBadRequest, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		export.Applied = true
+	}
+
+	if c.Query("format") == "yaml" {
+		manifest, err := yaml.Marshal(export.Workflow.Object)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		export.Manifest = string(manifest)
+	}
+	return http.StatusOK, export
+}
+
+func (k *HandlerGroup) listPipelines(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	k8sClient := k.Client
+
+	namespace, err := requestNamespace(c, nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "list"); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	pipelines, err := ListPipelines(c, k8sClient, namespace)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, pipelines
+}
+
+func (k *HandlerGroup) getPipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	k8sClient := k.Client
 
-	if err != nil{
+	namespace, err := requestNamespace(c, nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "get"); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	pipeline, err := GetPipeline(c, k8sClient, namespace, c.Param("id"))
+	if errors.Is(err, ErrPipelineNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, pipeline
+}
+
+func (k *HandlerGroup) getPipelineStatus(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	k8sClient := k.Client
+
+	namespace, err := requestNamespace(c, nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "get"); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	status, err := GetPipelineStatus(c, k8sClient, namespace, c.Param("id"))
+	if errors.Is(err, ErrPipelineNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, status
+}
+
+func (k *HandlerGroup) updatePipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	var payload model.PipelinePayload
+	k8sClient := k.Client
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		return http.StatusBadRequest, err
+	}
+	namespace, err := requestNamespace(c, &payload)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "update"); err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckServiceAccounts(c, k8sClient, namespace, info, payload); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	ctx := WithDefaults(WithRequestInfo(c, info), k.defaults())
+	pipeline, err := UpdatePipeline(ctx, k8sClient, payload, namespace, c.Param("id"))
+	if errors.Is(err, ErrPipelineNotFound) {
+		return http.StatusNotFound, err
+	}
+	var invalidErr *InvalidGraphError
+	if errors.As(err, &invalidErr) {
+		return http.StatusBadRequest, invalidErr
+	}
+	var deployErr *DeployError
+	if errors.As(err, &deployErr) {
+		return http.StatusBadRequest, deployErr
+	}
+	if err != nil {
 		return http.StatusBadRequest, err
 	}
 
 	return http.StatusOK, map[string]interface{}{
 		"message":   "success",
+		"id":        pipeline.ID,
+		"changes":   pipeline.Changes,
+		"resources": PipelineResourceNames(pipeline),
+	}
+}
+
+func (k *HandlerGroup) deletePipeline(s *server.APIServer, c *server.APICtx) (code int, obj interface{}) {
+	k8sClient := k.Client
+
+	namespace, err := requestNamespace(c, nil)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	info, err := newRequestInfo(c, k8sClient)
+	if err != nil {
+		return namespaceStatus(err), err
+	}
+	if err := CheckNamespace(c, k8sClient, namespace, info, "delete"); err != nil {
+		return namespaceStatus(err), err
+	}
+
+	err = DeletePipeline(c, k8sClient, namespace, c.Param("id"))
+	if errors.Is(err, ErrPipelineNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"message": "success",
+		"id":      c.Param("id"),
 	}
 }
 
@@ -86,25 +520,12 @@ func GetKsvcFromNode(client client.Client, ctx context.Context, namespace string
 	return ksvc, err
 }
 
-func getK8sClient() client.Client {
-	//TODO handle this kubeconfig part
-	kubeconfigPath := "/home/administrator/Documents/pipeline-api/conf/supervisorconf"
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if err != nil {
-		log.Fatalf("Error reading kubeconfig: %v", err)
-	}
-
-	err = serving.AddToScheme(scheme.Scheme)
-	err = flows.AddToScheme(scheme.Scheme)
-	err = messaging.AddToScheme(scheme.Scheme)
-	err = duck.AddToScheme(scheme.Scheme)
-
-	// Create the controller-runtime client
-	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
-	if err != nil {
-		log.Fatalf("Error creating Kubernetes client: %v", err)
+// ksvcAddress returns where a ksvc takes requests, preferring the cluster local address which skips the ingress
+func ksvcAddress(ksvc *serving.Service) *apis.URL {
+	if ksvc.Status.Address != nil && ksvc.Status.Address.URL != nil {
+		return ksvc.Status.Address.URL
 	}
-	return k8sClient
+	return ksvc.Status.URL
 }
 
 func GetValidNodes(c context.Context, k8sClient client.Client, namespace string, sequence []string, nodeList []model.Node) ([]string, error) {
@@ -130,10 +551,6 @@ func GetValidNodes(c context.Context, k8sClient client.Client, namespace string,
 	return validNodes, nil
 }
 
-func ApplySequence(ctx context.Context, k8sClient client.Client, sequence flows.Sequence) error {
-	return k8sClient.Create(ctx, &sequence)
-}
-
 func updateNode(nodeList []model.Node, sequenceStart string, SequenceId string) {
 	for i := range nodeList {
 		if nodeList[i].ID == sequenceStart {
@@ -177,10 +594,50 @@ func TranslateSequence(faasIdList []string, namespace string, sequenceName strin
 	}
 }
 
-func generateRandomString() string {
-	randomNumber := rand.Intn(999999) + 1
-	// Convert the number to a string
-	return strconv.Itoa(randomNumber)
+// findRootNode returns the first node without any incoming edges, the only one once ValidateRoot passed
+func findRootNode(nodeList []model.Node, edges []model.Edge) string {
+	hasIncoming := map[string]bool{}
+	for _, edge := range edges {
+		hasIncoming[edge.Target] = true
+	}
+	for _, node := range nodeList {
+		if !hasIncoming[node.ID] {
+			return node.ID
+		}
+	}
+	return ""
+}
+
+// sequenceReply routes the output of a sequence to whatever follows its last node:
+// the parallel when it is a fork, the sink it ends in, or the sequence of the join node otherwise
+func sequenceReply(lastNode string, outgoingNeighbors map[string][]string, sequenceNames map[string]string, parallelNames map[string]string, sinks map[string]duck.Destination) *duck.Destination {
+	if parallelName, isFork := parallelNames[lastNode]; isFork {
+		return &duck.Destination{
+			Ref: &duck.KReference{
+				APIVersion: "flows.knative.dev/v1",
+				Kind:       "Parallel",
+				Name:       parallelName,
+			},
+		}
+	}
+
+	next := outgoingNeighbors[lastNode]
+	if len(next) != 1 {
+		return nil
+	}
+	if sink, isSink := sinks[next[0]]; isSink {
+		return sink.DeepCopy()
+	}
+	if sequenceName, exists := sequenceNames[next[0]]; exists {
+		return &duck.Destination{
+			Ref: &duck.KReference{
+				APIVersion: "flows.knative.dev/v1",
+				Kind:       "Sequence",
+				Name:       sequenceName,
+			},
+		}
+	}
+	return nil
 }
 
 func TranslateParallel(branches []string, namespace string, parallelName string, nodeList []model.Node) flows.Parallel {
@@ -220,53 +677,237 @@ func TranslateParallel(branches []string, namespace string, parallelName string,
 	}
 }
 
-func ApplyParallel(ctx context.Context, k8sClient client.Client, parallel flows.Parallel) error {
-	return k8sClient.Create(ctx, &parallel)
+// keepProvenance leaves the request that created a resource on it when it is updated by a later one
+func keepProvenance(current map[string]string, desired map[string]string) {
+	if desired == nil {
+		return
+	}
+	for _, key := range []string{RequestIdAnnotation, CreatedByAnnotation} {
+		if value, exists := current[key]; exists {
+			desired[key] = value
+		} else {
+			delete(desired, key)
+		}
+	}
 }
 
-func ProcessPayload(k8sClient client.Client, ctx context.Context, payload model.PipelinePayload, namespace string) error {
-	// identify the parallels and sequences
-	parallels, sequences := helpers.TraverseGraph(
-		payload.Nodes, payload.Edges)
-
-	// handle sequences
-	// for each sequence in the sequences list, construct the knative sequence
-	for _, sequence := range sequences {
+func ProcessPayload(k8sClient client.Client, ctx context.Context, payload model.PipelinePayload, namespace string) (*model.Pipeline, error) {
+	pipelineId := helpers.PipelineID(payload.Name, payload.Nodes, payload.Edges)
+
+	// posting the same pipeline again redeploys it over what is already there, replacing it is left to UpdatePipeline
+	existing, err := GetPipeline(ctx, k8sClient, namespace, pipelineId)
+	if errors.Is(err, ErrPipelineNotFound) {
+		existing = &model.Pipeline{ID: pipelineId}
+	} else if err != nil {
+		return nil, err
+	} else if !sameGraph(existing, payload) {
+		return nil, fmt.Errorf("%w: %s", ErrPipelineExists, pipelineId)
+	}
+	return deployPipeline(ctx, k8sClient, payload, namespace, pipelineId, existing)
+}
 
-		// return a list of faas ids if the sequence is valid
-		validNodes, err := GetValidNodes(ctx, k8sClient, namespace, sequence, payload.Nodes)
-		if err != nil {
-			fmt.Println("Unable to validate nodes: ", err)
-			return err
+// sameGraph tells whether the payload is the graph stored for the pipeline, leaving out the sequence ids set on the nodes
+func sameGraph(pipeline *model.Pipeline, payload model.PipelinePayload) bool {
+	withoutSequenceIds := func(nodes []model.Node) []model.Node {
+		copied := make([]model.Node, len(nodes))
+		for i, node := range nodes {
+			copied[i] = node
+			copied[i].SequenceId = ""
 		}
+		return copied
+	}
+	stored := model.PipelinePayload{
+		Name:     pipeline.Name,
+		Delivery: pipeline.Delivery,
+		Channel:  pipeline.Channel,
+		Backend:  pipeline.Backend,
+		Nodes:    withoutSequenceIds(pipeline.Nodes),
+		Edges:    pipeline.Edges,
+	}
+	submitted := model.PipelinePayload{
+		Name:     payload.Name,
+		Delivery: payload.Delivery,
+		Channel:  payload.Channel,
+		Backend:  payload.Backend,
+		Nodes:    withoutSequenceIds(payload.Nodes),
+		Edges:    payload.Edges,
+	}
+	// nil and empty lists are the same graph
+	return equality.Semantic.DeepEqual(stored, submitted)
+}
 
-		// with the valid nodes, construct our sequence
-		sequenceName := "mocha-sequence-" + generateRandomString()
-		ksequence := TranslateSequence(validNodes, namespace, sequenceName)
+// UpdatePipeline redeploys an existing pipeline with a new graph. Sequences and parallels are matched to the
+// deployed ones by the node they start from, so they are updated in place and anything no longer in the graph is pruned.
+func UpdatePipeline(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload, namespace string, pipelineId string) (*model.Pipeline, error) {
+	existing, err := GetPipeline(ctx, k8sClient, namespace, pipelineId)
+	if err != nil {
+		return nil, err
+	}
+	return deployPipeline(ctx, k8sClient, payload, namespace, pipelineId, existing)
+}
 
-		err = ApplySequence(ctx, k8sClient, ksequence)
-		if err != nil {
-			fmt.Println("Unable to apply sequence: ", err)
-			return err
+func deployPipeline(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload, namespace string, pipelineId string, existing *model.Pipeline) (*model.Pipeline, error) {
+	pipeline, err := planPipeline(ctx, k8sClient, payload, namespace, pipelineId, existing)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.Changes = &model.PipelineChanges{}
+
+	// every change is tracked so a failure part way through leaves nothing behind
+	d := &deployment{}
+
+	// keep the submitted graph so the editor can reopen the exact canvas,
+	// the configmap is also the parent of every generated resource so it goes first
+	parent, previousParent, err := StorePipelineGraph(ctx, k8sClient, namespace, pipeline)
+	if err != nil {
+		fmt.Println("Unable to store pipeline graph: ", err)
+		return nil, d.fail(ctx, k8sClient, err)
+	}
+	if previousParent != nil {
+		d.trackUpdate(previousParent)
+	} else {
+		d.trackCreate(parent.DeepCopy())
+	}
+	if err := setOwnership(pipeline, parent, requestInfoFrom(ctx)); err != nil {
+		return nil, d.fail(ctx, k8sClient, err)
+	}
+
+	// the plan reuses the names of deployed resources, so anything left over is no longer in the graph.
+	// sources go last since they start sending events as soon as they are ready, and are pruned first
+	if err := reconcileObjects(ctx, k8sClient, d, pipelineObjects(pipeline), pipelineObjects(existing), pipeline.Changes); err != nil {
+		fmt.Println("Unable to deploy pipeline resources: ", err)
+		return nil, d.fail(ctx, k8sClient, err)
+	}
+	return pipeline, nil
+}
+
+// graphOwnerReferences makes a resource owned by the graph configmap, so it goes when the pipeline is deleted
+func graphOwnerReferences(parent *corev1.ConfigMap) []v1.OwnerReference {
+	return []v1.OwnerReference{
+		{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Name:       parent.Name,
+			UID:        parent.UID,
+		},
+	}
+}
+
+// setOwnership labels every generated resource with the pipeline so it can be found again whatever its kind, makes
+// the graph configmap its owner so deleting it cascades through garbage collection, and records the request that created it
+func setOwnership(pipeline *model.Pipeline, parent *corev1.ConfigMap, info RequestInfo) error {
+	ownerReferences := graphOwnerReferences(parent)
+	provenance := map[string]string{RequestIdAnnotation: info.ID}
+	if info.User != "" {
+		provenance[CreatedByAnnotation] = info.User
+	}
+
+	for i := range pipeline.Objects {
+		obj := &pipeline.Objects[i]
+		obj.SetOwnerReferences(ownerReferences)
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[PipelineIdLabel] = pipeline.ID
+		labels[ManagedByLabel] = ManagedBy
+		obj.SetLabels(labels)
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for key, value := range provenance {
+			if value != "" {
+				annotations[key] = value
+			}
 		}
+		obj.SetAnnotations(annotations)
+	}
+	return setObjects(pipeline, pipeline.Objects)
+}
 
-		// update the first node to set its sequence id
-		updateNode(payload.Nodes, sequence[0], sequenceName)
+// planPipeline validates the graph and hands it to the translator of its backend, which works out the resources
+// it needs without creating anything.
+func planPipeline(ctx context.Context, k8sClient client.Client, submitted model.PipelinePayload, namespace string, pipelineId string, existing *model.Pipeline) (*model.Pipeline, error) {
+	// the pipeline keeps the settings as submitted, the defaults only go into what is planned from them
+	payload := applyDefaults(ctx, submitted)
+
+	// reject bad graphs before anything touches the cluster
+	validationErrs := helpers.ValidateGraph(payload.Nodes, payload.Edges)
+	validationErrs = append(validationErrs, helpers.ValidateRoot(payload.Nodes, payload.Edges)...)
+	validationErrs = append(validationErrs, validateDelivery(ctx, payload)...)
+	translator, err := TranslatorFor(payload.Backend)
+	if err != nil {
+		validationErrs = append(validationErrs, model.ValidationError{Code: helpers.InvalidBackend, Message: err.Error()})
+	} else {
+		validationErrs = append(validationErrs, translator.Validate(ctx, k8sClient, payload)...)
+	}
+	if len(validationErrs) > 0 {
+		return nil, &InvalidGraphError{Message: "invalid pipeline graph", Errors: validationErrs}
+	}
 
+	pipeline := &model.Pipeline{
+		ID:       pipelineId,
+		Name:     submitted.Name,
+		Delivery: submitted.Delivery,
+		Channel:  submitted.Channel,
+		Backend:  submitted.Backend,
+		Nodes:    submitted.Nodes,
+		Edges:    submitted.Edges,
 	}
 
-	// handle parallels
-	for _, branches := range parallels {
-		// generate the parallel
-		parallelName := "mocha-parallel-" + generateRandomString()
-		kparallel := TranslateParallel(branches, namespace, parallelName, payload.Nodes)
+	// sequence ids are assigned by the translator, never trust the ones sent by the client
+	for i := range payload.Nodes {
+		payload.Nodes[i].SequenceId = ""
+	}
 
-		// apply the parallel
-		err := ApplyParallel(ctx, k8sClient, kparallel)
+	graph, err := newGraph(payload, namespace, pipelineId, existing)
+	if err != nil {
+		return nil, err
+	}
+	translated, err := translator.Translate(ctx, k8sClient, graph)
+	if err != nil {
+		return nil, err
+	}
+
+	// from here on every resource is handled the same way, whatever its kind
+	objects := []unstructured.Unstructured{}
+	for _, obj := range translated {
+		planned, err := toUnstructured(k8sClient, obj)
 		if err != nil {
-			fmt.Println("Unable to apply sequence: ", err)
-			return err
+			return nil, err
 		}
+		// the status is left to the cluster
+		unstructured.RemoveNestedField(planned.Object, "status")
+		unstructured.RemoveNestedField(planned.Object, "metadata", "creationTimestamp")
+		if planned.GetNamespace() == "" {
+			planned.SetNamespace(namespace)
+		}
+		objects = append(objects, *planned)
 	}
-	return nil
-}
\ No newline at end of file
+	if err := setObjects(pipeline, objects); err != nil {
+		return nil, err
+	}
+	pipeline.Entry = pipelineEntry(pipeline.Objects)
+	return pipeline, nil
+}
+
+// withoutEndpoints drops source nodes from the front of the chains and sinks from the end, along with chains left empty
+func withoutEndpoints(sequences [][]string, sources map[string]string, sinks map[string]duck.Destination) [][]string {
+	result := [][]string{}
+	for _, sequence := range sequences {
+		if _, isSource := sources[sequence[0]]; isSource {
+			sequence = sequence[1:]
+		}
+		if len(sequence) == 0 {
+			continue
+		}
+		if _, isSink := sinks[sequence[len(sequence)-1]]; isSink {
+			sequence = sequence[:len(sequence)-1]
+		}
+		if len(sequence) > 0 {
+			result = append(result, sequence)
+		}
+	}
+	return result
+}
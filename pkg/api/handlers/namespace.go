@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pcs-aa-aas/commons/pkg/api/server"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const DefaultNamespace = "default"
+
+var (
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrForbidden         = errors.New("forbidden")
+	ErrUnauthenticated   = errors.New("unauthenticated")
+)
+
+// requestNamespace takes the namespace from the namespace query parameter or the payload, falling back to the default
+func requestNamespace(c *server.APICtx, payload *model.PipelinePayload) (string, error) {
+	namespace := c.Query("namespace")
+	if payload != nil && payload.Namespace != "" {
+		if namespace != "" && namespace != payload.Namespace {
+			return "", fmt.Errorf("namespace %s in the query does not match namespace %s in the payload", namespace, payload.Namespace)
+		}
+		namespace = payload.Namespace
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return namespace, nil
+}
+
+// CheckNamespace makes sure the caller is authenticated, the namespace exists and the caller may do the verb on the
+// graph configmap and every kind of resource a backend deploys, along with any other kinds given
+func CheckNamespace(ctx context.Context, k8sClient client.Client, namespace string, info RequestInfo, verb string, kinds ...schema.GroupVersionKind) error {
+	// without an identity there is nobody to authorize, so nothing is allowed
+	if info.User == "" {
+		return fmt.Errorf("%w: no authenticated user", ErrUnauthenticated)
+	}
+
+	err := k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &corev1.Namespace{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, namespace)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+
+	// the api deploys with its own service account, so the caller has to be allowed the same on everything it deploys,
+	// or a source could run an image or watch the cluster with rights the caller does not have
+	kinds = append(append([]schema.GroupVersionKind{configMapKind}, managedKinds("")...), kinds...)
+	for _, kind := range kinds {
+		mapping, err := k8sClient.RESTMapper().RESTMapping(kind.GroupKind(), kind.Version)
+		if meta.IsNoMatchError(err) {
+			// nothing of a kind that is not installed gets deployed
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find the resource of %s: %w", kind.Kind, err)
+		}
+		err = reviewAccess(ctx, k8sClient, info, authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      verb,
+			Group:     mapping.Resource.Group,
+			Resource:  mapping.Resource.Resource,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckServiceAccounts makes sure the caller may impersonate the service accounts the sources of the payload run as,
+// since they get the rights of those accounts
+func CheckServiceAccounts(ctx context.Context, k8sClient client.Client, namespace string, info RequestInfo, payload model.PipelinePayload) error {
+	for _, node := range payload.Nodes {
+		if node.Type != model.ApiServerSourceNode || node.Data.ApiServerSource == nil || node.Data.ApiServerSource.ServiceAccountName == "" {
+			continue
+		}
+		err := reviewAccess(ctx, k8sClient, info, authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "impersonate",
+			Resource:  "serviceaccounts",
+			Name:      node.Data.ApiServerSource.ServiceAccountName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reviewAccess asks the api server whether the caller may do what the attributes describe
+func reviewAccess(ctx context.Context, k8sClient client.Client, info RequestInfo, attributes authorizationv1.ResourceAttributes) error {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               info.User,
+			Groups:             info.Groups,
+			ResourceAttributes: &attributes,
+		},
+	}
+	if err := k8sClient.Create(ctx, review); err != nil {
+		return fmt.Errorf("failed to review access for %s: %w", info.User, err)
+	}
+	if !review.Status.Allowed {
+		resource := attributes.Resource
+		if attributes.Name != "" {
+			resource += " " + attributes.Name
+		}
+		return fmt.Errorf("%w: %s cannot %s %s in namespace %s", ErrForbidden, info.User, attributes.Verb, resource, attributes.Namespace)
+	}
+	return nil
+}
+
+// namespaceStatus maps a failed namespace check to its response code
+func namespaceStatus(err error) int {
+	if errors.Is(err, ErrUnauthenticated) {
+		return http.StatusUnauthorized
+	}
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
+	flows "knative.dev/eventing/pkg/apis/flows/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PipelineIdLabel ties every generated knative resource back to the pipeline that created it
+	PipelineIdLabel = "mocha/pipeline-id"
+	// NodesAnnotation records the ordered node ids that make up a sequence
+	NodesAnnotation = "mocha/nodes"
+	// ForkNodeAnnotation records the node id that a parallel fans out from
+	ForkNodeAnnotation = "mocha/fork-node"
+	// EntryAnnotation marks the sequence that events enter the pipeline through
+	EntryAnnotation = "mocha/entry"
+	// RequestIdAnnotation records the request that created a resource
+	RequestIdAnnotation = "mocha/request-id"
+	// CreatedByAnnotation records the caller that created a resource, when known
+	CreatedByAnnotation = "mocha/created-by"
+	// ManagedByLabel marks every resource generated by this api
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "pipeline-api"
+	// GraphKey is the configmap key holding the submitted graph
+	GraphKey = "graph"
+)
+
+var (
+	ErrPipelineNotFound = errors.New("pipeline not found")
+	// ErrPipelineExists is returned when a new pipeline takes the id of a deployed one with a different graph
+	ErrPipelineExists = errors.New("a different pipeline with this id already exists")
+)
+
+// InvalidGraphError is returned when the submitted graph fails validation, before anything touches the cluster
+type InvalidGraphError struct {
+	Message string                  `json:"message"`
+	Errors  []model.ValidationError `json:"errors"`
+}
+
+func (e *InvalidGraphError) Error() string {
+	messages := []string{}
+	for _, validationErr := range e.Errors {
+		messages = append(messages, validationErr.Message)
+	}
+	return e.Message + ": " + strings.Join(messages, "; ")
+}
+
+// Kinds of the resources a pipeline response has a field for
+var (
+	sequenceKind = flows.SchemeGroupVersion.WithKind("Sequence")
+	parallelKind = flows.SchemeGroupVersion.WithKind("Parallel")
+	brokerKind   = eventing.SchemeGroupVersion.WithKind("Broker")
+	triggerKind  = eventing.SchemeGroupVersion.WithKind("Trigger")
+	// configMapKind is the kind the graph of a pipeline is stored as
+	configMapKind = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+)
+
+// ListPipelines returns every pipeline in the namespace, grouped by the pipeline id label
+func ListPipelines(ctx context.Context, k8sClient client.Client, namespace string) ([]model.Pipeline, error) {
+	objects, err := listObjects(ctx, k8sClient, managedKinds(""), client.InNamespace(namespace), client.HasLabels{PipelineIdLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pipeline resources in namespace %s: %w", namespace, err)
+	}
+
+	pipelines := map[string]*model.Pipeline{}
+	getOrCreate := func(id string) *model.Pipeline {
+		if _, exists := pipelines[id]; !exists {
+			pipelines[id] = &model.Pipeline{ID: id}
+		}
+		return pipelines[id]
+	}
+	for _, obj := range objects {
+		pipeline := getOrCreate(obj.GetLabels()[PipelineIdLabel])
+		pipeline.Objects = append(pipeline.Objects, obj)
+	}
+
+	graphList := &corev1.ConfigMapList{}
+	if err := k8sClient.List(ctx, graphList, client.InNamespace(namespace), client.HasLabels{PipelineIdLabel}); err != nil {
+		return nil, fmt.Errorf("failed to list pipeline graphs in namespace %s: %w", namespace, err)
+	}
+	graphs := map[string]*model.PipelinePayload{}
+	for _, configMap := range graphList.Items {
+		graph, err := decodeGraph(&configMap)
+		if err != nil {
+			return nil, err
+		}
+		id := configMap.Labels[PipelineIdLabel]
+		getOrCreate(id)
+		graphs[id] = graph
+	}
+
+	result := []model.Pipeline{}
+	for id, pipeline := range pipelines {
+		if err := setObjects(pipeline, pipeline.Objects); err != nil {
+			return nil, err
+		}
+		if graph, exists := graphs[id]; exists {
+			pipeline.Name, pipeline.Delivery, pipeline.Channel = graph.Name, graph.Delivery, graph.Channel
+			pipeline.Backend, pipeline.Nodes, pipeline.Edges = graph.Backend, graph.Nodes, graph.Edges
+		} else {
+			pipeline.Nodes, pipeline.Edges = BuildGraph(pipeline.Sequences, pipeline.Parallels)
+		}
+		pipeline.Entry = pipelineEntry(pipeline.Objects)
+		result = append(result, *pipeline)
+	}
+
+	// keep the output stable for the UI
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// GetPipeline returns a single pipeline along with all the resources that belong to it, whatever their kind
+func GetPipeline(ctx context.Context, k8sClient client.Client, namespace string, pipelineId string) (*model.Pipeline, error) {
+	graph, err := GetPipelineGraph(ctx, k8sClient, namespace, pipelineId)
+	if err != nil && !errors.Is(err, ErrPipelineNotFound) {
+		return nil, err
+	}
+	backend := ""
+	if graph != nil {
+		backend = graph.Backend
+	}
+
+	objects, err := listObjects(ctx, k8sClient, managedKinds(backend), client.InNamespace(namespace), client.MatchingLabels{PipelineIdLabel: pipelineId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resources for pipeline %s: %w", pipelineId, err)
+	}
+	workflows, err := listObjects(ctx, k8sClient, []schema.GroupVersionKind{workflowTemplateKind}, client.InNamespace(namespace), client.MatchingLabels{PipelineIdLabel: pipelineId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflows for pipeline %s: %w", pipelineId, err)
+	}
+	if graph == nil && len(objects) == 0 && len(workflows) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPipelineNotFound, pipelineId)
+	}
+
+	pipeline := &model.Pipeline{ID: pipelineId}
+	// exported workflows are applied on their own, they are not part of what a deploy reconciles
+	for _, workflow := range workflows {
+		pipeline.Workflows = append(pipeline.Workflows, workflow.GetName())
+	}
+	if err := setObjects(pipeline, objects); err != nil {
+		return nil, err
+	}
+	if graph != nil {
+		pipeline.Name, pipeline.Delivery, pipeline.Channel = graph.Name, graph.Delivery, graph.Channel
+		pipeline.Backend, pipeline.Nodes, pipeline.Edges = graph.Backend, graph.Nodes, graph.Edges
+	} else {
+		// pipelines deployed before the graph was stored have to be rebuilt from their resources
+		pipeline.Nodes, pipeline.Edges = BuildGraph(pipeline.Sequences, pipeline.Parallels)
+	}
+	pipeline.Entry = pipelineEntry(pipeline.Objects)
+	return pipeline, nil
+}
+
+// listObjects lists the resources of every kind matching the options, kinds that are not installed are skipped
+func listObjects(ctx context.Context, k8sClient client.Client, kinds []schema.GroupVersionKind, opts ...client.ListOption) ([]unstructured.Unstructured, error) {
+	objects := []unstructured.Unstructured{}
+	for _, kind := range kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(kind.GroupVersion().WithKind(kind.Kind + "List"))
+		err := k8sClient.List(ctx, list, opts...)
+		if meta.IsNoMatchError(err) {
+			// e.g. knative eventing installed without one of the sources
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", kind.Kind, err)
+		}
+		for _, obj := range list.Items {
+			// the graph is labelled like the resources but it is not one of them
+			if obj.GetKind() == "ConfigMap" && obj.GetName() == obj.GetLabels()[PipelineIdLabel] {
+				continue
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// setObjects keeps the resources of a pipeline and fills in the fields of the kinds the response has one for
+func setObjects(pipeline *model.Pipeline, objects []unstructured.Unstructured) error {
+	pipeline.Objects = objects
+	pipeline.Sequences, pipeline.Parallels = []flows.Sequence{}, []flows.Parallel{}
+	pipeline.Brokers, pipeline.Triggers, pipeline.Sources = nil, nil, nil
+
+	for _, obj := range objects {
+		var err error
+		switch gvk := obj.GroupVersionKind(); {
+		case gvk == sequenceKind:
+			sequence := flows.Sequence{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &sequence)
+			pipeline.Sequences = append(pipeline.Sequences, sequence)
+		case gvk == parallelKind:
+			parallel := flows.Parallel{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &parallel)
+			pipeline.Parallels = append(pipeline.Parallels, parallel)
+		case gvk == brokerKind:
+			broker := eventing.Broker{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &broker)
+			pipeline.Brokers = append(pipeline.Brokers, broker)
+		case gvk == triggerKind:
+			trigger := eventing.Trigger{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &trigger)
+			pipeline.Triggers = append(pipeline.Triggers, trigger)
+		case isSourceKind(gvk):
+			pipeline.Sources = append(pipeline.Sources, obj)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// pipelineEntry returns the resource that events enter the pipeline through, along with its address once knative has set one
+func pipelineEntry(objects []unstructured.Unstructured) *model.PipelineEntry {
+	for _, obj := range objects {
+		if obj.GetAnnotations()[EntryAnnotation] != "true" {
+			continue
+		}
+		entry := &model.PipelineEntry{Kind: obj.GetKind(), Name: obj.GetName()}
+		entry.Address, _, _ = unstructured.NestedString(obj.Object, "status", "address", "url")
+		return entry
+	}
+	return nil
+}
+
+// PipelineResourceNames lists the resources of a pipeline and the resource behind each node
+func PipelineResourceNames(pipeline *model.Pipeline) model.PipelineResources {
+	resources := model.PipelineResources{
+		Sequences: []string{},
+		Parallels: []string{},
+		Nodes:     map[string]string{},
+	}
+	for _, sequence := range pipeline.Sequences {
+		resources.Sequences = append(resources.Sequences, sequence.Name)
+		for _, nodeId := range strings.Split(sequence.Annotations[NodesAnnotation], ",") {
+			resources.Nodes[nodeId] = sequence.Name
+		}
+	}
+	for _, parallel := range pipeline.Parallels {
+		resources.Parallels = append(resources.Parallels, parallel.Name)
+	}
+	for _, broker := range pipeline.Brokers {
+		resources.Brokers = append(resources.Brokers, broker.Name)
+		// with the broker backend every function runs behind the broker
+		for _, node := range pipeline.Nodes {
+			if !helpers.IsSink(node) {
+				resources.Nodes[node.ID] = broker.Name
+			}
+		}
+	}
+	for _, trigger := range pipeline.Triggers {
+		resources.Triggers = append(resources.Triggers, trigger.Name)
+	}
+	for _, source := range pipeline.Sources {
+		resources.Sources = append(resources.Sources, source.GetName())
+		resources.Nodes[source.GetAnnotations()[SourceNodeAnnotation]] = source.GetName()
+	}
+	for _, obj := range pipeline.Objects {
+		switch gvk := obj.GroupVersionKind(); {
+		case gvk == sequenceKind, gvk == parallelKind, gvk == brokerKind, gvk == triggerKind, isSourceKind(gvk):
+			continue
+		}
+		resources.Others = append(resources.Others, obj.GetKind()+"/"+obj.GetName())
+		if nodeIds := obj.GetAnnotations()[NodesAnnotation]; nodeIds != "" {
+			for _, nodeId := range strings.Split(nodeIds, ",") {
+				resources.Nodes[nodeId] = obj.GetName()
+			}
+		}
+	}
+	return resources
+}
+
+// refreshEntry picks up the entry address when knative has already made the entry resource addressable
+func refreshEntry(ctx context.Context, k8sClient client.Client, namespace string, pipeline *model.Pipeline) error {
+	if pipeline.Entry == nil {
+		return nil
+	}
+	for _, obj := range pipeline.Objects {
+		if obj.GetAnnotations()[EntryAnnotation] != "true" {
+			continue
+		}
+		current := &unstructured.Unstructured{}
+		current.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: namespace}, current); err != nil {
+			return fmt.Errorf("failed to get entry %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		if entry := pipelineEntry([]unstructured.Unstructured{*current}); entry != nil {
+			pipeline.Entry = entry
+		}
+		return nil
+	}
+	return nil
+}
+
+// DeletePipeline removes every resource that belongs to the pipeline, whatever its kind
+func DeletePipeline(ctx context.Context, k8sClient client.Client, namespace string, pipelineId string) error {
+	pipeline, err := GetPipeline(ctx, k8sClient, namespace, pipelineId)
+	if err != nil {
+		return err
+	}
+
+	// remove them in the reverse of the order they are applied, sources and parallels go before the sequences they point at
+	for i := len(pipeline.Objects) - 1; i >= 0; i-- {
+		obj := &pipeline.Objects[i]
+		if err := client.IgnoreNotFound(k8sClient.Delete(ctx, obj)); err != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+
+	// workflows applied before the pipeline was deployed are not owned by its graph
+	workflow := &unstructured.Unstructured{}
+	workflow.SetGroupVersionKind(workflowTemplateKind)
+	err = k8sClient.DeleteAllOf(ctx, workflow, client.InNamespace(namespace), client.MatchingLabels{PipelineIdLabel: pipelineId})
+	if err != nil && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("failed to delete workflows for pipeline %s: %w", pipelineId, err)
+	}
+
+	// the configmap owns the resources above, garbage collection picks up anything that was missed
+	err = k8sClient.DeleteAllOf(ctx, &corev1.ConfigMap{},
+		client.InNamespace(namespace), client.MatchingLabels{PipelineIdLabel: pipelineId},
+		client.PropagationPolicy(v1.DeletePropagationBackground))
+	if err != nil {
+		return fmt.Errorf("failed to delete graph for pipeline %s: %w", pipelineId, err)
+	}
+	return nil
+}
+
+// StorePipelineGraph saves or replaces the nodes and edges of a pipeline in a labelled configmap named after the pipeline.
+// It returns the stored configmap, and its previous state when it was replaced.
+func StorePipelineGraph(ctx context.Context, k8sClient client.Client, namespace string, pipeline *model.Pipeline) (*corev1.ConfigMap, *corev1.ConfigMap, error) {
+	graph, err := json.Marshal(model.PipelinePayload{
+		Name:     pipeline.Name,
+		Delivery: pipeline.Delivery,
+		Channel:  pipeline.Channel,
+		Backend:  pipeline.Backend,
+		Nodes:    pipeline.Nodes,
+		Edges:    pipeline.Edges,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode graph for pipeline %s: %w", pipeline.ID, err)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      pipeline.ID,
+			Namespace: namespace,
+			Labels:    map[string]string{PipelineIdLabel: pipeline.ID, ManagedByLabel: ManagedBy},
+		},
+		Data: map[string]string{
+			GraphKey: string(graph),
+		},
+	}
+	err = k8sClient.Create(ctx, configMap)
+	if !apierrors.IsAlreadyExists(err) {
+		return configMap, nil, err
+	}
+
+	// the pipeline is being redeployed, replace the stored graph
+	current := &corev1.ConfigMap{}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), current); err != nil {
+		return nil, nil, err
+	}
+	if current.Labels[PipelineIdLabel] != pipeline.ID {
+		return nil, nil, fmt.Errorf("configmap %s already exists and does not belong to a pipeline", configMap.Name)
+	}
+	previous := current.DeepCopy()
+	current.Labels = configMap.Labels
+	current.Data = configMap.Data
+	return current, previous, k8sClient.Update(ctx, current)
+}
+
+// GetPipelineGraph returns the nodes and edges that were submitted for the pipeline
+func GetPipelineGraph(ctx context.Context, k8sClient client.Client, namespace string, pipelineId string) (*model.PipelinePayload, error) {
+	configMap := &corev1.ConfigMap{}
+	typeNamespacedName := types.NamespacedName{
+		Name:      pipelineId,
+		Namespace: namespace,
+	}
+
+	err := k8sClient.Get(ctx, typeNamespacedName, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrPipelineNotFound, pipelineId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get graph for pipeline %s: %w", pipelineId, err)
+	}
+	if configMap.Labels[PipelineIdLabel] != pipelineId {
+		// a configmap that happens to share the name is not ours
+		return nil, fmt.Errorf("%w: %s", ErrPipelineNotFound, pipelineId)
+	}
+	return decodeGraph(configMap)
+}
+
+func decodeGraph(configMap *corev1.ConfigMap) (*model.PipelinePayload, error) {
+	graph := &model.PipelinePayload{}
+	if err := json.Unmarshal([]byte(configMap.Data[GraphKey]), graph); err != nil {
+		return nil, fmt.Errorf("failed to decode graph in configmap %s: %w", configMap.Name, err)
+	}
+	return graph, nil
+}
+
+// BuildGraph rebuilds the nodes and edges of a pipeline from its deployed sequences and parallels
+func BuildGraph(sequences []flows.Sequence, parallels []flows.Parallel) ([]model.Node, []model.Edge) {
+	nodes := []model.Node{}
+	edges := []model.Edge{}
+	seen := map[string]bool{}
+	sequenceHeads := map[string]string{}
+
+	addNode := func(node model.Node) {
+		if seen[node.ID] {
+			return
+		}
+		seen[node.ID] = true
+		nodes = append(nodes, node)
+	}
+	addEdge := func(source string, target string) {
+		edges = append(edges, model.Edge{ID: source + "-" + target, Source: source, Target: target})
+	}
+
+	for _, sequence := range sequences {
+		nodeIds := strings.Split(sequence.Annotations[NodesAnnotation], ",")
+		if len(nodeIds) != len(sequence.Spec.Steps) {
+			// not annotated by us, nothing to rebuild from
+			continue
+		}
+		sequenceHeads[sequence.Name] = nodeIds[0]
+
+		for i, nodeId := range nodeIds {
+			node := model.Node{ID: nodeId}
+			if ref := sequence.Spec.Steps[i].Ref; ref != nil {
+				node.Data = model.NodeData{Label: ref.Name, FaasID: ref.Name}
+			}
+			// the head of the sequence is the node that carries the sequence id
+			if i == 0 {
+				node.SequenceId = sequence.Name
+			}
+			addNode(node)
+
+			if i > 0 {
+				addEdge(nodeIds[i-1], nodeId)
+			}
+		}
+	}
+
+	for _, parallel := range parallels {
+		forkNode := parallel.Annotations[ForkNodeAnnotation]
+		if forkNode == "" {
+			continue
+		}
+		addNode(model.Node{ID: forkNode})
+
+		for _, branch := range parallel.Spec.Branches {
+			if branch.Subscriber.Ref == nil {
+				continue
+			}
+			if head, exists := sequenceHeads[branch.Subscriber.Ref.Name]; exists {
+				addEdge(forkNode, head)
+			}
+		}
+	}
+	return nodes, edges
+}
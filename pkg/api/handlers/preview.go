@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PreviewPipeline translates the graph into the sequences and parallels a deploy would create, without creating them.
+// With serverDryRun every resource is also sent to the API server in dry run mode so admission errors show up before a real deploy,
+// as an update of the deployed resource when there is one.
+func PreviewPipeline(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload, namespace string, serverDryRun bool) (*model.PipelinePreview, error) {
+	pipelineId := helpers.PipelineID(payload.Name, payload.Nodes, payload.Edges)
+
+	// preview against what is deployed so the names match what a deploy would produce
+	existing, err := GetPipeline(ctx, k8sClient, namespace, pipelineId)
+	if errors.Is(err, ErrPipelineNotFound) {
+		existing = &model.Pipeline{ID: pipelineId}
+	} else if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := planPipeline(ctx, k8sClient, payload, namespace, pipelineId, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &model.PipelinePreview{Pipeline: *pipeline}
+	if !serverDryRun {
+		return preview, nil
+	}
+
+	// resources that are already deployed are checked as the update a deploy would send, the others as a create
+	deployed := map[objectKey]*unstructured.Unstructured{}
+	for i := range existing.Objects {
+		key, err := keyOf(k8sClient, &existing.Objects[i])
+		if err != nil {
+			return nil, err
+		}
+		deployed[key] = &existing.Objects[i]
+	}
+
+	preview.DryRunErrors = []model.ResourceError{}
+	for _, obj := range pipelineObjects(pipeline) {
+		key, err := keyOf(k8sClient, obj)
+		if err != nil {
+			return nil, err
+		}
+		if current, exists := deployed[key]; exists {
+			desired, convertErr := toUnstructured(k8sClient, obj)
+			if convertErr != nil {
+				return nil, convertErr
+			}
+			err = k8sClient.Update(ctx, mergeObject(current, desired), client.DryRunAll)
+		} else {
+			err = k8sClient.Create(ctx, obj, client.DryRunAll)
+		}
+		if err != nil {
+			preview.DryRunErrors = append(preview.DryRunErrors, model.ResourceError{
+				Kind:    key.gvk.Kind,
+				Name:    obj.GetName(),
+				Message: err.Error(),
+			})
+		}
+	}
+	return preview, nil
+}
+
+// RenderManifests renders the sequences and parallels of a pipeline as a multi document YAML
+func RenderManifests(pipeline *model.Pipeline) (string, error) {
+	documents := []string{}
+	for _, obj := range pipelineObjects(pipeline) {
+		document, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("failed to render %s: %w", obj.GetName(), err)
+		}
+		documents = append(documents, string(document))
+	}
+	return strings.Join(documents, "---\n"), nil
+}
+
+// pipelineObjects returns copies of every resource in the pipeline in the order they are applied
+func pipelineObjects(pipeline *model.Pipeline) []client.Object {
+	objects := []client.Object{}
+	for _, obj := range pipeline.Objects {
+		objects = append(objects, obj.DeepCopy())
+	}
+	return objects
+}
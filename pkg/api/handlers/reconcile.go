@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// annotationPrefix is shared by every annotation this api sets
+const annotationPrefix = "mocha/"
+
+// objectKey identifies a resource by its kind and name, resources of different kinds can share a name
+type objectKey struct {
+	gvk  schema.GroupVersionKind
+	name string
+}
+
+func keyOf(k8sClient client.Client, obj client.Object) (objectKey, error) {
+	gvk, err := apiutil.GVKForObject(obj, k8sClient.Scheme())
+	if err != nil {
+		return objectKey{}, fmt.Errorf("failed to get the kind of %s: %w", obj.GetName(), err)
+	}
+	return objectKey{gvk: gvk, name: obj.GetName()}, nil
+}
+
+// reconcileObjects creates or updates the desired resources in order, then deletes the existing ones that are no
+// longer desired in the reverse of their order. Every change is tracked in the deployment and listed in changes.
+func reconcileObjects(ctx context.Context, k8sClient client.Client, d *deployment, desired []client.Object, existing []client.Object, changes *model.PipelineChanges) error {
+	stale := map[objectKey]client.Object{}
+	for _, obj := range existing {
+		key, err := keyOf(k8sClient, obj)
+		if err != nil {
+			return err
+		}
+		stale[key] = obj
+	}
+
+	for _, obj := range desired {
+		key, err := keyOf(k8sClient, obj)
+		if err != nil {
+			return err
+		}
+		current, exists := stale[key]
+		if !exists {
+			if err := k8sClient.Create(ctx, obj.DeepCopyObject().(client.Object)); err != nil {
+				return fmt.Errorf("failed to create %s %s: %w", key.gvk.Kind, key.name, err)
+			}
+			d.trackCreate(obj.DeepCopyObject().(client.Object))
+			changes.Created = append(changes.Created, key.name)
+			continue
+		}
+		delete(stale, key)
+		changed, err := updateObject(ctx, k8sClient, current, obj)
+		if err != nil {
+			return fmt.Errorf("failed to update %s %s: %w", key.gvk.Kind, key.name, err)
+		}
+		if changed {
+			d.trackUpdate(current.DeepCopyObject().(client.Object))
+			changes.Updated = append(changes.Updated, key.name)
+		}
+	}
+
+	for i := len(existing) - 1; i >= 0; i-- {
+		key, _ := keyOf(k8sClient, existing[i])
+		obj, isStale := stale[key]
+		if !isStale {
+			continue
+		}
+		if err := client.IgnoreNotFound(k8sClient.Delete(ctx, obj)); err != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", key.gvk.Kind, key.name, err)
+		}
+		d.trackDelete(obj.DeepCopyObject().(client.Object))
+		changes.Deleted = append(changes.Deleted, key.name)
+	}
+	return nil
+}
+
+// updateObject brings a deployed resource in line with the desired one, returning whether anything changed.
+// The update is sent as a dry run first, so whatever the cluster fills in by itself does not count as a change.
+func updateObject(ctx context.Context, k8sClient client.Client, current client.Object, desired client.Object) (bool, error) {
+	currentObj, err := toUnstructured(k8sClient, current)
+	if err != nil {
+		return false, err
+	}
+	desiredObj, err := toUnstructured(k8sClient, desired)
+	if err != nil {
+		return false, err
+	}
+
+	dryRun := mergeObject(currentObj, desiredObj)
+	if err := k8sClient.Update(ctx, dryRun, client.DryRunAll); err != nil {
+		return false, err
+	}
+	if !objectChanged(currentObj, dryRun) {
+		return false, nil
+	}
+	return true, k8sClient.Update(ctx, mergeObject(currentObj, desiredObj))
+}
+
+// mergeObject returns the current resource with the content, labels and owners of the desired one. Annotations
+// set by others are kept, the ones of this api are replaced, apart from the request that created the resource.
+func mergeObject(current *unstructured.Unstructured, desired *unstructured.Unstructured) *unstructured.Unstructured {
+	merged := current.DeepCopy()
+	for key := range merged.Object {
+		if isContent(key) {
+			delete(merged.Object, key)
+		}
+	}
+	for key, value := range desired.DeepCopy().Object {
+		if isContent(key) {
+			merged.Object[key] = value
+		}
+	}
+	merged.SetLabels(desired.GetLabels())
+	merged.SetOwnerReferences(desired.GetOwnerReferences())
+
+	annotations := map[string]string{}
+	for key, value := range current.GetAnnotations() {
+		if !strings.HasPrefix(key, annotationPrefix) {
+			annotations[key] = value
+		}
+	}
+	desiredAnnotations := desired.GetAnnotations()
+	keepProvenance(current.GetAnnotations(), desiredAnnotations)
+	for key, value := range desiredAnnotations {
+		annotations[key] = value
+	}
+	merged.SetAnnotations(annotations)
+	return merged
+}
+
+// objectChanged reports whether an update would change the content, labels, annotations or owners of a resource
+func objectChanged(current *unstructured.Unstructured, updated *unstructured.Unstructured) bool {
+	for _, obj := range []*unstructured.Unstructured{current, updated} {
+		for key := range obj.Object {
+			if isContent(key) && !equality.Semantic.DeepEqual(current.Object[key], updated.Object[key]) {
+				return true
+			}
+		}
+	}
+	return !equality.Semantic.DeepEqual(current.GetLabels(), updated.GetLabels()) ||
+		!equality.Semantic.DeepEqual(current.GetAnnotations(), updated.GetAnnotations()) ||
+		!equality.Semantic.DeepEqual(current.GetOwnerReferences(), updated.GetOwnerReferences())
+}
+
+// isContent reports whether a top level field is the content of a resource, spec for most kinds
+func isContent(key string) bool {
+	switch key {
+	case "apiVersion", "kind", "metadata", "status":
+		return false
+	}
+	return true
+}
+
+// toUnstructured converts a resource of any kind, filling in its kind from the scheme when it is not set
+func toUnstructured(k8sClient client.Client, obj client.Object) (*unstructured.Unstructured, error) {
+	if u, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+		return u.DeepCopy(), nil
+	}
+	gvk, err := apiutil.GVKForObject(obj, k8sClient.Scheme())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the kind of %s: %w", obj.GetName(), err)
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s %s: %w", gvk.Kind, obj.GetName(), err)
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pcs-aa-aas/commons/pkg/api/server"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RequestIdHeader lets callers correlate their request with the resources it created
+	RequestIdHeader = "X-Request-Id"
+	// AuthorizationHeader carries the bearer token the caller is authenticated with
+	AuthorizationHeader = "Authorization"
+)
+
+type requestInfoKey struct{}
+
+// RequestInfo identifies the request and the caller behind a deploy
+type RequestInfo struct {
+	ID     string
+	User   string
+	Groups []string
+}
+
+// WithRequestInfo attaches the request info to the context so it ends up on every resource the deploy creates
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func requestInfoFrom(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// newRequestInfo identifies the request and authenticates the caller by their bearer token
+func newRequestInfo(c *server.APICtx, k8sClient client.Client) (RequestInfo, error) {
+	token, hasToken := strings.CutPrefix(c.GetHeader(AuthorizationHeader), "Bearer ")
+	if !hasToken || strings.TrimSpace(token) == "" {
+		return RequestInfo{}, fmt.Errorf("%w: no bearer token", ErrUnauthenticated)
+	}
+	info, err := Authenticate(c, k8sClient, strings.TrimSpace(token))
+	if err != nil {
+		return RequestInfo{}, err
+	}
+	info.ID = c.GetHeader(RequestIdHeader)
+	if info.ID == "" {
+		info.ID = uuid.NewString()
+	}
+	return info, nil
+}
+
+// Authenticate resolves a bearer token to the user and groups behind it with a TokenReview, so the identity
+// authorized against the namespace is the one the API server vouches for rather than anything the caller claims
+func Authenticate(ctx context.Context, k8sClient client.Client, token string) (RequestInfo, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := k8sClient.Create(ctx, review); err != nil {
+		return RequestInfo{}, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !review.Status.Authenticated || review.Status.User.Username == "" {
+		reason := review.Status.Error
+		if reason == "" {
+			reason = "the token is not valid"
+		}
+		return RequestInfo{}, fmt.Errorf("%w: %s", ErrUnauthenticated, reason)
+	}
+	return RequestInfo{User: review.Status.User.Username, Groups: review.Status.User.Groups}, nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DeployError is returned when a deploy fails part way through. Every change already made for the
+// request has been undone, RolledBack lists those resources and NotRolledBack lists any that could not be undone.
+type DeployError struct {
+	Err           error    `json:"-"`
+	Message       string   `json:"message"`
+	RolledBack    []string `json:"rolledBack"`
+	NotRolledBack []string `json:"notRolledBack,omitempty"`
+}
+
+func (e *DeployError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeployError) Unwrap() error {
+	return e.Err
+}
+
+// deployment records every change made to the cluster while deploying a pipeline
+type deployment struct {
+	created []client.Object
+	// the state before the update
+	updated []client.Object
+	// the state before the delete
+	deleted []client.Object
+}
+
+func (d *deployment) trackCreate(obj client.Object) {
+	d.created = append(d.created, obj)
+}
+
+func (d *deployment) trackUpdate(previous client.Object) {
+	d.updated = append(d.updated, previous)
+}
+
+func (d *deployment) trackDelete(previous client.Object) {
+	d.deleted = append(d.deleted, previous)
+}
+
+// fail undoes the deployment and wraps err with what was rolled back
+func (d *deployment) fail(ctx context.Context, k8sClient client.Client, err error) *DeployError {
+	deployErr := &DeployError{
+		Err:        err,
+		Message:    err.Error(),
+		RolledBack: []string{},
+	}
+
+	undo := func(name string, rollbackErr error) {
+		if rollbackErr != nil {
+			fmt.Printf("Unable to roll back %s: %v\n", name, rollbackErr)
+			deployErr.NotRolledBack = append(deployErr.NotRolledBack, name)
+			return
+		}
+		deployErr.RolledBack = append(deployErr.RolledBack, name)
+	}
+
+	// undo in reverse order so nothing is left pointing at a resource that is already gone
+	for i := len(d.deleted) - 1; i >= 0; i-- {
+		obj := d.deleted[i]
+		obj.SetResourceVersion("")
+		obj.SetUID("")
+		undo(obj.GetName(), client.IgnoreAlreadyExists(k8sClient.Create(ctx, obj)))
+	}
+	for i := len(d.updated) - 1; i >= 0; i-- {
+		undo(d.updated[i].GetName(), restore(ctx, k8sClient, d.updated[i]))
+	}
+	for i := len(d.created) - 1; i >= 0; i-- {
+		obj := d.created[i]
+		undo(obj.GetName(), client.IgnoreNotFound(k8sClient.Delete(ctx, obj)))
+	}
+	return deployErr
+}
+
+// restore puts an updated object back to its previous state
+func restore(ctx context.Context, k8sClient client.Client, previous client.Object) error {
+	current := previous.DeepCopyObject().(client.Object)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(previous), current); err != nil {
+		return err
+	}
+	previous.SetResourceVersion(current.GetResourceVersion())
+	return k8sClient.Update(ctx, previous)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+	duck "knative.dev/pkg/apis/duck/v1"
+)
+
+// sinkDestination translates a sink node into the destination sequences and parallels reply to
+func sinkDestination(node model.Node) (*duck.Destination, error) {
+	sink, err := destination(node.Type, node.Data.Sink)
+	if err != nil {
+		return nil, fmt.Errorf("sink node %s: %w", node.ID, err)
+	}
+	return sink, nil
+}
+
+// destination points at a broker, channel or service in the pipeline namespace, or at an external uri
+func destination(sinkType string, sink *model.SinkConfig) (*duck.Destination, error) {
+	if sink == nil {
+		return nil, fmt.Errorf("no sink configured")
+	}
+
+	ref := &duck.KReference{Name: sink.Name}
+	switch sinkType {
+	case model.BrokerNode:
+		ref.APIVersion, ref.Kind = "eventing.knative.dev/v1", "Broker"
+	case model.ChannelNode:
+		ref.APIVersion, ref.Kind = "messaging.knative.dev/v1", "Channel"
+	case model.ServiceNode:
+		ref.APIVersion, ref.Kind = "v1", "Service"
+	case model.URINode:
+		uri, err := apis.ParseURL(sink.URI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uri: %w", err)
+		}
+		return &duck.Destination{URI: uri}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %s", sinkType)
+	}
+	return &duck.Destination{Ref: ref}, nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	sourcesv1 "knative.dev/eventing/pkg/apis/sources/v1"
+	duck "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/tracker"
+)
+
+// SourceNodeAnnotation records the node a knative source was generated from
+const SourceNodeAnnotation = "mocha/source-node"
+
+// sourceKinds maps the source node types to the knative source they become
+var sourceKinds = map[string]string{
+	model.ApiServerSourceNode: "ApiServerSource",
+	model.PingSourceNode:      "PingSource",
+	model.ContainerSourceNode: "ContainerSource",
+	model.SinkBindingNode:     "SinkBinding",
+}
+
+// TranslateSource builds the knative source for a source node, sending its events to sink
+func TranslateSource(node model.Node, namespace string, sourceName string, sink duck.Destination) (*unstructured.Unstructured, error) {
+	objectMeta := v1.ObjectMeta{
+		Name:      sourceName,
+		Namespace: namespace,
+	}
+	sourceSpec := duck.SourceSpec{Sink: sink}
+
+	var source runtime.Object
+	switch node.Type {
+	case model.ApiServerSourceNode:
+		config := node.Data.ApiServerSource
+		resources := []sourcesv1.APIVersionKindSelector{}
+		for _, resource := range config.Resources {
+			selector := sourcesv1.APIVersionKindSelector{APIVersion: resource.APIVersion, Kind: resource.Kind}
+			if len(resource.LabelSelector) > 0 {
+				selector.LabelSelector = &v1.LabelSelector{MatchLabels: resource.LabelSelector}
+			}
+			resources = append(resources, selector)
+		}
+		mode := config.Mode
+		if mode == "" {
+			mode = sourcesv1.ReferenceMode
+		}
+		source = &sourcesv1.ApiServerSource{
+			ObjectMeta: objectMeta,
+			Spec: sourcesv1.ApiServerSourceSpec{
+				SourceSpec:         sourceSpec,
+				Resources:          resources,
+				EventMode:          mode,
+				ServiceAccountName: config.ServiceAccountName,
+			},
+		}
+	case model.PingSourceNode:
+		config := node.Data.PingSource
+		source = &sourcesv1.PingSource{
+			ObjectMeta: objectMeta,
+			Spec: sourcesv1.PingSourceSpec{
+				SourceSpec:  sourceSpec,
+				Schedule:    config.Schedule,
+				Timezone:    config.Timezone,
+				ContentType: config.ContentType,
+				Data:        config.Data,
+			},
+		}
+	case model.ContainerSourceNode:
+		config := node.Data.ContainerSource
+		env := []corev1.EnvVar{}
+		for name, value := range config.Env {
+			env = append(env, corev1.EnvVar{Name: name, Value: value})
+		}
+		// keep the spec stable so redeploys do not see a change
+		sort.Slice(env, func(i, j int) bool {
+			return env[i].Name < env[j].Name
+		})
+		source = &sourcesv1.ContainerSource{
+			ObjectMeta: objectMeta,
+			Spec: sourcesv1.ContainerSourceSpec{
+				SourceSpec: sourceSpec,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "source", Image: config.Image, Args: config.Args, Env: env},
+						},
+					},
+				},
+			},
+		}
+	case model.SinkBindingNode:
+		config := node.Data.SinkBinding
+		subject := tracker.Reference{
+			APIVersion: config.APIVersion,
+			Kind:       config.Kind,
+			Namespace:  namespace,
+			Name:       config.Name,
+		}
+		if len(config.Selector) > 0 {
+			subject.Selector = &v1.LabelSelector{MatchLabels: config.Selector}
+		}
+		source = &sourcesv1.SinkBinding{
+			ObjectMeta: objectMeta,
+			Spec: sourcesv1.SinkBindingSpec{
+				SourceSpec:  sourceSpec,
+				BindingSpec: duck.BindingSpec{Subject: subject},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("node %s has unknown source type %s", node.ID, node.Type)
+	}
+
+	// sources are handled as unstructured objects so every kind goes through the same code
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert source %s: %w", sourceName, err)
+	}
+	delete(object, "status")
+	ksource := &unstructured.Unstructured{Object: object}
+	ksource.SetGroupVersionKind(sourcesv1.SchemeGroupVersion.WithKind(sourceKinds[node.Type]))
+	return ksource, nil
+}
+
+// translateSources builds the knative source of every source node, sinkFor gives where the events of the node a source
+// points at have to be delivered
+func translateSources(payload model.PipelinePayload, namespace string, pipelineId string, sourceTargets map[string]string, sinkFor func(target string) duck.Destination) ([]unstructured.Unstructured, error) {
+	sources := []unstructured.Unstructured{}
+	for _, node := range payload.Nodes {
+		target, isSource := sourceTargets[node.ID]
+		if !isSource {
+			continue
+		}
+		ksource, err := TranslateSource(node, namespace, helpers.ResourceName(pipelineId, node.Type, node.ID), sinkFor(target))
+		if err != nil {
+			return nil, err
+		}
+		ksource.SetLabels(map[string]string{PipelineIdLabel: pipelineId, ManagedByLabel: ManagedBy})
+		ksource.SetAnnotations(map[string]string{SourceNodeAnnotation: node.ID})
+		sources = append(sources, *ksource)
+	}
+	return sources, nil
+}
+
+// isSourceKind reports whether the kind is one of the knative sources
+func isSourceKind(gvk schema.GroupVersionKind) bool {
+	return gvk.Group == sourcesv1.SchemeGroupVersion.Group
+}
+
+// sourceGVKs lists every kind of knative source a source node can become
+func sourceGVKs() []schema.GroupVersionKind {
+	kinds := []string{}
+	for _, kind := range sourceKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	gvks := []schema.GroupVersionKind{}
+	for _, kind := range kinds {
+		gvks = append(gvks, sourcesv1.SchemeGroupVersion.WithKind(kind))
+	}
+	return gvks
+}
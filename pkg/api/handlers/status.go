@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"knative.dev/pkg/apis"
+	duck "knative.dev/pkg/apis/duck/v1"
+	serving "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MaxWait caps how long a request may wait for its pipeline to become ready
+const MaxWait = 5 * time.Minute
+
+// NotReadyError is returned when the pipeline is still not ready once the wait is over, Blocking lists the resources
+// that hold it back and Nodes the nodes that are not ready, whose services may be what is left
+type NotReadyError struct {
+	Message        string                      `json:"message"`
+	Entry          *model.PipelineEntry        `json:"entry,omitempty"`
+	ReadyResources int                         `json:"readyResources"`
+	TotalResources int                         `json:"totalResources"`
+	Blocking       []model.ResourceStatus      `json:"blocking"`
+	Nodes          map[string]model.NodeStatus `json:"nodes,omitempty"`
+}
+
+func (e *NotReadyError) Error() string {
+	return e.Message
+}
+
+// WaitForPipeline watches the resources of the pipeline and the services of its nodes until the pipeline is Ready, every
+// one of them reporting Ready=True, or the timeout runs out. The status is read again whenever one of them changes,
+// rather than on a fixed interval.
+func WaitForPipeline(ctx context.Context, k8sClient client.WithWatch, namespace string, pipelineId string, timeout time.Duration) (*model.PipelineStatus, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// watch before the first read so no change in between is missed
+	changed := make(chan struct{}, 1)
+	if err := watchPipeline(waitCtx, k8sClient, namespace, pipelineId, changed); err != nil {
+		return nil, err
+	}
+
+	var status *model.PipelineStatus
+	for {
+		current, err := GetPipelineStatus(waitCtx, k8sClient, namespace, pipelineId)
+		switch {
+		case err == nil:
+			status = current
+			if status.Ready {
+				return status, nil
+			}
+		case waitCtx.Err() == nil || status == nil:
+			return nil, err
+		}
+
+		select {
+		case <-changed:
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// the last status read is what the pipeline looked like when time ran out
+			notReadyNodes := map[string]model.NodeStatus{}
+			for nodeId, node := range status.Nodes {
+				if !node.Ready {
+					notReadyNodes[nodeId] = node
+				}
+			}
+			return status, &NotReadyError{
+				Message:        fmt.Sprintf("pipeline %s was not ready after %s", pipelineId, timeout),
+				Entry:          status.Entry,
+				ReadyResources: status.ReadyResources,
+				TotalResources: len(status.Resources),
+				Blocking:       status.Blocking,
+				Nodes:          notReadyNodes,
+			}
+		}
+	}
+}
+
+// watchPipeline signals on changed whenever a resource of the pipeline changes, of any kind a translator can return,
+// or a knative service in the namespace does, until ctx is done. Kinds that are not installed are skipped, and watches
+// the server closes are opened again.
+func watchPipeline(ctx context.Context, k8sClient client.WithWatch, namespace string, pipelineId string, changed chan<- struct{}) error {
+	watched := map[schema.GroupVersionKind][]client.ListOption{}
+	for _, kind := range managedKinds("") {
+		watched[kind] = []client.ListOption{client.InNamespace(namespace), client.MatchingLabels{PipelineIdLabel: pipelineId}}
+	}
+	// the functions are not labelled with the pipelines they run in
+	watched[serving.SchemeGroupVersion.WithKind("Service")] = []client.ListOption{client.InNamespace(namespace)}
+
+	for kind, opts := range watched {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(kind.GroupVersion().WithKind(kind.Kind + "List"))
+		watcher, err := k8sClient.Watch(ctx, list, opts...)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to watch %s for pipeline %s: %w", kind.Kind, pipelineId, err)
+		}
+
+		go func(watcher watch.Interface) {
+			var err error
+			for {
+				for range watcher.ResultChan() {
+					select {
+					case changed <- struct{}{}:
+					default:
+						// a read is already due, it will see this change too
+					}
+				}
+				watcher.Stop()
+				if ctx.Err() != nil {
+					return
+				}
+				if watcher, err = k8sClient.Watch(ctx, list, opts...); err != nil {
+					fmt.Printf("Unable to watch %s for pipeline %s: %v\n", kind.Kind, pipelineId, err)
+					return
+				}
+			}
+		}(watcher)
+	}
+	return nil
+}
+
+// parseWait reads the wait query parameter, an empty value means not waiting at all
+func parseWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait %q: %w", value, err)
+	}
+	if timeout <= 0 || timeout > MaxWait {
+		return 0, fmt.Errorf("invalid wait %q: must be between 0s and %s", value, MaxWait)
+	}
+	return timeout, nil
+}
+
+// GetPipelineStatus collects the readiness of every resource and service behind a pipeline
+func GetPipelineStatus(ctx context.Context, k8sClient client.Client, namespace string, pipelineId string) (*model.PipelineStatus, error) {
+	pipeline, err := GetPipeline(ctx, k8sClient, namespace, pipelineId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &model.PipelineStatus{
+		ID:        pipelineId,
+		Ready:     true,
+		Entry:     pipeline.Entry,
+		Resources: []model.ResourceStatus{},
+		Nodes:     map[string]model.NodeStatus{},
+	}
+
+	// every node runs behind the resources annotated with it, a trigger only counts for the node it delivers to
+	behind := map[string][]model.ResourceStatus{}
+	sequenceOf := map[string]string{}
+	triggersInto := map[string][]string{}
+	sources := map[string]model.ResourceStatus{}
+	for i := range pipeline.Objects {
+		obj := &pipeline.Objects[i]
+		resource := resourceStatus(obj.GetKind(), obj, objectStatus(obj))
+		status.Resources = append(status.Resources, resource)
+		status.Ready = status.Ready && resource.Ready
+		if resource.Ready {
+			status.ReadyResources++
+		} else {
+			status.Blocking = append(status.Blocking, resource)
+		}
+
+		annotations := obj.GetAnnotations()
+		if nodeId, isSource := annotations[SourceNodeAnnotation]; isSource {
+			sources[nodeId] = resource
+			continue
+		}
+		if annotations[NodesAnnotation] == "" {
+			continue
+		}
+		nodeIds := strings.Split(annotations[NodesAnnotation], ",")
+		switch obj.GroupVersionKind() {
+		case triggerKind:
+			nodeIds = nodeIds[len(nodeIds)-1:]
+			triggersInto[nodeIds[0]] = append(triggersInto[nodeIds[0]], obj.GetName())
+		case sequenceKind:
+			for _, nodeId := range nodeIds {
+				sequenceOf[nodeId] = obj.GetName()
+			}
+		}
+		for _, nodeId := range nodeIds {
+			behind[nodeId] = append(behind[nodeId], resource)
+		}
+	}
+
+	for _, node := range pipeline.Nodes {
+		if source, isSource := sources[node.ID]; isSource {
+			status.Nodes[node.ID] = model.NodeStatus{Ready: source.Ready, Source: source.Name, Reasons: source.Reasons}
+			continue
+		}
+		if helpers.IsSink(node) {
+			// sinks are only referenced by the pipeline, they are not part of it
+			continue
+		}
+
+		nodeStatus := model.NodeStatus{
+			Ready:    true,
+			Sequence: sequenceOf[node.ID],
+			Triggers: triggersInto[node.ID],
+			Service:  node.Data.FaasID,
+		}
+		notReady := func(reason string) {
+			nodeStatus.Ready = false
+			nodeStatus.Reasons = append(nodeStatus.Reasons, reason)
+		}
+
+		if len(behind[node.ID]) == 0 {
+			notReady("not deployed in any resource")
+		}
+		for _, resource := range behind[node.ID] {
+			for _, reason := range resource.Reasons {
+				notReady(fmt.Sprintf("%s %s: %s", strings.ToLower(resource.Kind), resource.Name, reason))
+			}
+		}
+
+		ksvc, err := GetKsvcFromNode(k8sClient, ctx, namespace, &node)
+		if err != nil {
+			notReady(fmt.Sprintf("service %s: %v", node.Data.FaasID, err))
+		} else if service := resourceStatus("Service", ksvc, &ksvc.Status.Status); !service.Ready {
+			for _, reason := range service.Reasons {
+				notReady(fmt.Sprintf("service %s: %s", ksvc.Name, reason))
+			}
+		}
+
+		status.Nodes[node.ID] = nodeStatus
+		status.Ready = status.Ready && nodeStatus.Ready
+	}
+	return status, nil
+}
+
+// resourceStatus reads the Ready condition knative sets on the resource, listing every condition holding it back
+func resourceStatus(kind string, obj client.Object, status *duck.Status) model.ResourceStatus {
+	resource := model.ResourceStatus{Kind: kind, Name: obj.GetName()}
+
+	if status.ObservedGeneration != obj.GetGeneration() {
+		resource.Reasons = append(resource.Reasons, "latest changes not reconciled yet")
+		return resource
+	}
+	ready := status.GetCondition(apis.ConditionReady)
+	if ready == nil {
+		resource.Reasons = append(resource.Reasons, "Ready condition not reported yet")
+		return resource
+	}
+	if ready.IsTrue() {
+		resource.Ready = true
+		return resource
+	}
+	for _, condition := range status.Conditions {
+		if condition.IsTrue() {
+			continue
+		}
+		resource.Reasons = append(resource.Reasons,
+			strings.TrimSpace(fmt.Sprintf("%s: %s %s", condition.Type, condition.Reason, condition.Message)))
+	}
+	return resource
+}
+
+// objectStatus reads the knative status of a resource of any kind
+func objectStatus(obj *unstructured.Unstructured) *duck.Status {
+	status := &duck.Status{}
+	if object, found, _ := unstructured.NestedMap(obj.Object, "status"); found {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, status); err != nil {
+			fmt.Printf("Unable to read status of %s %s: %v\n", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return status
+}
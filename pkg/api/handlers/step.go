@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ServiceParam is the query parameter the step service gets the name of the ksvc to call in
+const ServiceParam = "service"
+
+// StepHandler is the step service the triggers of the broker backend call functions through. It gets the node id in
+// the StepExtension query parameter and the name of the function's ksvc in the service one, passes the event on to
+// the ksvc in its own namespace and replies with what the function replied, with the StepExtension set to the node id.
+// Replies can not keep the step of the event they answer that way, so a function echoing its input does not match its
+// own trigger again. Only ksvcs in the namespace are called, and the credentials of the caller are not passed on.
+//
+// A reply without an event gets an empty 202, and a reply with a body but no CloudEvent headers keeps the attributes
+// of the event it answers under a new id. Function errors are replied with as they are, for the trigger to retry.
+type StepHandler struct {
+	// Namespace is where the functions are, the one of the pipelines the service runs for
+	Namespace string
+	// HTTPClient calls the functions, http.DefaultClient when not set
+	HTTPClient *http.Client
+}
+
+// strippedHeaders are the headers of the trigger request that are not passed on to the function
+var strippedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Content-Length"}
+
+func (h StepHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Namespace == "" {
+		http.Error(w, "the step service has no namespace to call functions in", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	step, service := query.Get(StepExtension), query.Get(ServiceParam)
+	if step == "" || len(validation.IsDNS1123Label(service)) > 0 {
+		http.Error(w, fmt.Sprintf("the %s and %s query parameters are required, %s as the name of a ksvc", StepExtension, ServiceParam, ServiceParam), http.StatusBadRequest)
+		return
+	}
+	target := functionURL(service, h.Namespace)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event, err := requestEvent(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.Header = r.Header.Clone()
+	for _, name := range strippedHeaders {
+		request.Header.Del(name)
+	}
+	httpClient := h.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to call %s: %v", target, err), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+	reply, err := io.ReadAll(response.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read the reply of %s: %v", target, err), http.StatusBadGateway)
+		return
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		w.WriteHeader(response.StatusCode)
+		_, _ = w.Write(reply)
+		return
+	}
+
+	header, reply, err := stepReply(*event, step, response.Header, reply)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid reply from %s: %v", target, err), http.StatusBadGateway)
+		return
+	}
+	if header == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(reply)
+}
+
+// stepReply returns the headers and body of the reply with the step set on it, nil headers when there is no event
+func stepReply(request model.ExecutionEvent, step string, header http.Header, body []byte) (http.Header, []byte, error) {
+	if strings.HasPrefix(header.Get("Content-Type"), structuredContentType) {
+		attributes := map[string]interface{}{}
+		if err := json.Unmarshal(body, &attributes); err != nil {
+			return nil, nil, fmt.Errorf("invalid structured CloudEvent: %w", err)
+		}
+		attributes[StepExtension] = step
+		stamped, err := json.Marshal(attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return http.Header{"Content-Type": {header.Get("Content-Type")}}, stamped, nil
+	}
+
+	reply := http.Header{}
+	if header.Get("Ce-Id") == "" {
+		if len(body) == 0 {
+			return nil, nil, nil
+		}
+		// a plain body answers the event, so it goes on with its attributes
+		reply.Set("Ce-Specversion", "1.0")
+		reply.Set("Ce-Id", uuid.NewString())
+		reply.Set("Ce-Source", request.Source)
+		reply.Set("Ce-Type", request.Type)
+		if request.Subject != "" {
+			reply.Set("Ce-Subject", request.Subject)
+		}
+		for name, value := range request.Extensions {
+			reply.Set("Ce-"+name, value)
+		}
+	} else {
+		for name, values := range header {
+			if strings.HasPrefix(strings.ToLower(name), "ce-") {
+				reply[name] = values
+			}
+		}
+	}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		reply.Set("Content-Type", contentType)
+	}
+	reply.Set("Ce-"+StepExtension, step)
+	return reply, body, nil
+}
+
+// functionURL is the address a ksvc is reached at from inside the cluster, known before knative reports it
+func functionURL(faasId string, namespace string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local", faasId, namespace)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"aaaas/pipeline-api/pkg/api/helpers"
+	"aaaas/pipeline-api/pkg/api/model"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	duck "knative.dev/pkg/apis/duck/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrUnknownBackend = errors.New("unknown backend")
+
+// Translator turns a validated graph into the kubernetes resources that run it. Deploys, updates, status
+// and deletes work on the resources it returns by their kind and the pipeline label, whichever translator produced them.
+type Translator interface {
+	// Validate reports what the translator can not express, along with anything it needs from the cluster
+	Validate(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload) []model.ValidationError
+	// Kinds lists every kind of resource Translate can return, deployed pipelines are looked up in each of them
+	Kinds() []schema.GroupVersionKind
+	// Translate returns the resources of the pipeline in the order they are applied, without creating them.
+	// The resource annotated with EntryAnnotation is where events enter the pipeline.
+	Translate(ctx context.Context, k8sClient client.Client, graph *Graph) ([]client.Object, error)
+}
+
+// Graph is a validated payload split into the parts translators work from
+type Graph struct {
+	model.PipelinePayload
+	Namespace  string
+	PipelineID string
+	// Existing is what is deployed for the pipeline, so resources can keep their names
+	Existing *model.Pipeline
+	// SourceTargets maps every source node to the node it points at
+	SourceTargets map[string]string
+	// Sinks maps every sink node to where it delivers
+	Sinks map[string]duck.Destination
+	// Functions and FunctionEdges leave out the source nodes and the edges leaving them
+	Functions     []model.Node
+	FunctionEdges []model.Edge
+	// EntryNode is the root function that events sent to the pipeline go to
+	EntryNode string
+}
+
+// translators holds the backends a payload can pick from
+var translators = map[string]Translator{
+	model.FlowsBackend:  flowsTranslator{},
+	model.BrokerBackend: brokerTranslator{},
+}
+
+// RegisterTranslator makes a translator available as a backend, replacing any registered under the same name.
+// It is meant to be called at startup, before the server handles any request.
+func RegisterTranslator(backend string, translator Translator) {
+	translators[backend] = translator
+}
+
+// TranslatorFor returns the translator of a backend, the flows translator when none is given
+func TranslatorFor(backend string) (Translator, error) {
+	if backend == "" {
+		backend = model.FlowsBackend
+	}
+	translator, exists := translators[backend]
+	if !exists {
+		backends := []string{}
+		for name := range translators {
+			backends = append(backends, name)
+		}
+		sort.Strings(backends)
+		return nil, fmt.Errorf("%w %s, use one of %s", ErrUnknownBackend, backend, strings.Join(backends, ", "))
+	}
+	return translator, nil
+}
+
+// managedKinds lists the kinds of every registered translator, those of the backend first and in its order
+func managedKinds(backend string) []schema.GroupVersionKind {
+	if backend == "" {
+		backend = model.FlowsBackend
+	}
+	backends := []string{backend}
+	others := []string{}
+	for name := range translators {
+		if name != backend {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+
+	kinds := []schema.GroupVersionKind{}
+	seen := map[schema.GroupVersionKind]bool{}
+	for _, name := range append(backends, others...) {
+		translator, exists := translators[name]
+		if !exists {
+			continue
+		}
+		for _, kind := range translator.Kinds() {
+			if !seen[kind] {
+				seen[kind] = true
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	return kinds
+}
+
+// newGraph splits the payload into its sources, sinks and functions
+func newGraph(payload model.PipelinePayload, namespace string, pipelineId string, existing *model.Pipeline) (*Graph, error) {
+	graph := &Graph{
+		PipelinePayload: payload,
+		Namespace:       namespace,
+		PipelineID:      pipelineId,
+		Existing:        existing,
+		SourceTargets:   map[string]string{},
+		Sinks:           map[string]duck.Destination{},
+		Functions:       []model.Node{},
+		FunctionEdges:   []model.Edge{},
+	}
+
+	// sources become knative sources of their own rather than steps, they feed the node they point at
+	// and sinks are where the output of the pipeline is delivered
+	for _, node := range payload.Nodes {
+		switch {
+		case helpers.IsSource(node):
+			graph.SourceTargets[node.ID] = ""
+		case helpers.IsSink(node):
+			sink, err := sinkDestination(node)
+			if err != nil {
+				return nil, err
+			}
+			graph.Sinks[node.ID] = *sink
+		default:
+			graph.Functions = append(graph.Functions, node)
+		}
+	}
+	for _, edge := range payload.Edges {
+		if _, isSource := graph.SourceTargets[edge.Source]; isSource {
+			graph.SourceTargets[edge.Source] = edge.Target
+		} else {
+			graph.FunctionEdges = append(graph.FunctionEdges, edge)
+		}
+	}
+
+	// events enter the pipeline through the root node
+	graph.EntryNode = findRootNode(graph.Functions, graph.FunctionEdges)
+	return graph, nil
+}
@@ -4,8 +4,10 @@ import (
 	"aaaas/pipeline-api/pkg/api/model"
 )
 
-// FindNodesWithMultipleOutgoingEdgesAndNeighbors identifies nodes with multiple outgoing edges and their neighbors,
-// and identifies single path nodes (nodes part of chains), excluding already visited nodes.
+// TraverseGraph identifies nodes with multiple outgoing edges and their neighbors (parallels),
+// and splits the graph into single path chains (sequences) so that every node belongs to exactly one chain.
+// A chain starts at a root node, at a branch of a parallel, or at a join node with more than one incoming edge,
+// and it ends at a fork node or right before the next join.
 func TraverseGraph(
 	nodes []model.Node, edges []model.Edge) (map[string][]string, [][]string) {
 
@@ -32,16 +34,26 @@ func TraverseGraph(
 		}
 	}
 
+	// A node starts a new chain unless it has a single parent that leads only to it
+	startsChain := func(nodeId string) bool {
+		parents := incomingNeighbors[nodeId]
+		if len(parents) != 1 {
+			return true // root or join node
+		}
+		return len(outgoingNeighbors[parents[0]]) > 1 // branch of a parallel
+	}
+
+	visitedGlobal := make(map[string]bool)
+
 	// Function to traverse a single chain
 	traverseChain := func(startNode string) []string {
 		chain := []string{}
 		currentNode := startNode
-		visited := make(map[string]bool)
 
 		for {
 			// Add the current node to the chain
 			chain = append(chain, currentNode)
-			visited[currentNode] = true
+			visitedGlobal[currentNode] = true
 
 			// Get the next node
 			nextNodes, exists := outgoingNeighbors[currentNode]
@@ -50,7 +62,10 @@ func TraverseGraph(
 			}
 
 			nextNode := nextNodes[0]
-			if visited[nextNode] {
+			if startsChain(nextNode) {
+				break // The next node is a join and gets a chain of its own
+			}
+			if visitedGlobal[nextNode] {
 				break // Prevent infinite loops
 			}
 
@@ -59,24 +74,17 @@ func TraverseGraph(
 		return chain
 	}
 
-	// Find all chains starting from nodes with zero or one outgoing edge
-	visitedGlobal := make(map[string]bool)
+	// Find all chains from the nodes that start one
 	for _, node := range nodes {
-		if visitedGlobal[node.ID] {
-			continue
+		if !visitedGlobal[node.ID] && startsChain(node.ID) {
+			sequences = append(sequences, traverseChain(node.ID))
 		}
+	}
 
-		// Only start chains from nodes with zero or one outgoing edge
-		if len(outgoingNeighbors[node.ID]) <= 1 {
-			chain := traverseChain(node.ID)
-
-			// Mark all nodes in the chain as globally visited
-			for _, n := range chain {
-				visitedGlobal[n] = true
-			}
-
-			// Add the chain to the result
-			sequences = append(sequences, chain)
+	// Nodes that are only reachable through a cycle have no start, pick them up so nothing is dropped
+	for _, node := range nodes {
+		if !visitedGlobal[node.ID] {
+			sequences = append(sequences, traverseChain(node.ID))
 		}
 	}
 	return parallels, sequences
@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxNameLength keeps names within the DNS-1123 label limit, they are also used as label values
+	maxNameLength = 63
+	// hashLength is the number of hex characters kept from a hash
+	hashLength = 10
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// PipelineID derives the id of a pipeline from its name. Pipelines without a name get an id from a hash of
+// their graph, so posting the same graph again reaches the same pipeline instead of creating a duplicate.
+func PipelineID(name string, nodes []model.Node, edges []model.Edge) string {
+	if id := sanitizeName(name); id != "" {
+		if len(id) > maxNameLength {
+			// keep it readable but unique
+			id = strings.TrimRight(id[:maxNameLength-hashLength-1], "-") + "-" + hash(name)
+		}
+		return id
+	}
+
+	// only the parts of the graph that change what gets deployed, positions and labels are cosmetic
+	parts := []string{}
+	for _, node := range nodes {
+		parts = append(parts, "node:"+node.ID+":"+node.Data.FaasID)
+	}
+	for _, edge := range edges {
+		parts = append(parts, "edge:"+edge.Source+":"+edge.Target)
+	}
+	sort.Strings(parts)
+	return "mocha-" + hash(parts...)
+}
+
+// ResourceName derives the name of a generated resource from the pipeline id, the kind of resource
+// and the ids of the nodes it covers. The result is stable and stays within the DNS-1123 label limit.
+func ResourceName(pipelineId string, kind string, nodeIds ...string) string {
+	suffix := "-" + kind + "-" + hash(append([]string{pipelineId, kind}, nodeIds...)...)
+	prefix := pipelineId
+	if len(prefix)+len(suffix) > maxNameLength {
+		prefix = strings.TrimRight(prefix[:maxNameLength-len(suffix)], "-")
+	}
+	return prefix + suffix
+}
+
+// TaskName derives the name of the workflow task running a node. Workflow engines do not allow task names starting
+// with a digit, so the id is prefixed, and ids that had to be changed get a hash to keep them unique.
+func TaskName(nodeId string) string {
+	name := "node-" + sanitizeName(nodeId)
+	if name != "node-"+nodeId || len(name) > maxNameLength {
+		if len(name) > maxNameLength-hashLength-1 {
+			name = name[:maxNameLength-hashLength-1]
+		}
+		name = strings.TrimRight(name, "-") + "-" + hash(nodeId)
+	}
+	return name
+}
+
+// sanitizeName lowercases the name and replaces anything that is not allowed in a DNS-1123 label
+func sanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(name, "-")
+}
+
+func hash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
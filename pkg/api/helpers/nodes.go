@@ -0,0 +1,23 @@
+package helpers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+)
+
+// IsSource reports whether the node is an event source rather than a function
+func IsSource(node model.Node) bool {
+	switch node.Type {
+	case model.ApiServerSourceNode, model.PingSourceNode, model.ContainerSourceNode, model.SinkBindingNode:
+		return true
+	}
+	return false
+}
+
+// IsSink reports whether the node is a destination for the pipeline output rather than a function
+func IsSink(node model.Node) bool {
+	switch node.Type {
+	case model.BrokerNode, model.ChannelNode, model.ServiceNode, model.URINode:
+		return true
+	}
+	return false
+}
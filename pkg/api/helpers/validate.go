@@ -0,0 +1,355 @@
+package helpers
+
+import (
+	"aaaas/pipeline-api/pkg/api/model"
+	"fmt"
+	"net/url"
+)
+
+// Validation error codes, stable so the UI can switch on them
+const (
+	DuplicateNode = "duplicate_node"
+	MissingFaasID = "missing_faas_id"
+	UnknownNode   = "unknown_node"
+	SelfLoop      = "self_loop"
+	DuplicateEdge = "duplicate_edge"
+	Cycle         = "cycle"
+	InvalidSource = "invalid_source"
+	SourceInput   = "source_input"
+	SourceOutput  = "source_output"
+	InvalidSink   = "invalid_sink"
+	SinkInput     = "sink_input"
+	SinkOutput    = "sink_output"
+	// InvalidCondition is reported for edge conditions that can not be turned into a branch filter
+	InvalidCondition = "invalid_condition"
+
+	// These are checked against knative and the cluster when planning
+	// InvalidDelivery is reported when the delivery settings are rejected
+	InvalidDelivery = "invalid_delivery"
+	// UnknownChannel is reported when the chosen channel kind is not installed in the cluster
+	UnknownChannel = "unknown_channel"
+	// InvalidBackend is reported when the pipeline asks for a backend that does not exist
+	InvalidBackend = "invalid_backend"
+	// InvalidRoot is reported when events sent to the pipeline have no single function to enter through
+	InvalidRoot = "invalid_root"
+	// ReservedNodeID is reported when a node id means something else to the backend
+	ReservedNodeID = "reserved_node_id"
+)
+
+// ValidateGraph checks the nodes and edges before anything is deployed and returns every problem found,
+// each tied to the node or edge that caused it. An empty list means the graph is valid.
+func ValidateGraph(nodes []model.Node, edges []model.Edge) []model.ValidationError {
+	errs := []model.ValidationError{}
+
+	// check the nodes
+	nodeIds := make(map[string]bool)
+	sinkIds := make(map[string]bool)
+	for _, node := range nodes {
+		if nodeIds[node.ID] {
+			errs = append(errs, model.ValidationError{
+				Code:    DuplicateNode,
+				Message: fmt.Sprintf("node id %s is used more than once", node.ID),
+				NodeID:  node.ID,
+			})
+		}
+		nodeIds[node.ID] = true
+
+		if IsSource(node) {
+			errs = append(errs, validateSource(node)...)
+		} else if IsSink(node) {
+			sinkIds[node.ID] = true
+			errs = append(errs, validateSink(node)...)
+		} else if node.Data.FaasID == "" {
+			errs = append(errs, model.ValidationError{
+				Code:    MissingFaasID,
+				Message: fmt.Sprintf("node %s is not mapped to a function", node.ID),
+				NodeID:  node.ID,
+			})
+		}
+	}
+
+	// check the edges, only well formed edges are kept for the cycle check
+	outgoingEdges := make(map[string][]model.Edge)
+	incomingEdges := make(map[string][]model.Edge)
+	seenEdges := make(map[string]bool)
+	for _, edge := range edges {
+		valid := true
+		for _, nodeId := range []string{edge.Source, edge.Target} {
+			if !nodeIds[nodeId] {
+				errs = append(errs, model.ValidationError{
+					Code:    UnknownNode,
+					Message: fmt.Sprintf("edge %s points to unknown node %s", edge.ID, nodeId),
+					EdgeID:  edge.ID,
+				})
+				valid = false
+			}
+		}
+
+		if edge.Source == edge.Target {
+			errs = append(errs, model.ValidationError{
+				Code:    SelfLoop,
+				Message: fmt.Sprintf("edge %s connects node %s to itself", edge.ID, edge.Source),
+				EdgeID:  edge.ID,
+			})
+			valid = false
+		}
+
+		key := edge.Source + "->" + edge.Target
+		if seenEdges[key] {
+			errs = append(errs, model.ValidationError{
+				Code:    DuplicateEdge,
+				Message: fmt.Sprintf("edge %s duplicates another edge from %s to %s", edge.ID, edge.Source, edge.Target),
+				EdgeID:  edge.ID,
+			})
+			valid = false
+		}
+		seenEdges[key] = true
+
+		if valid {
+			outgoingEdges[edge.Source] = append(outgoingEdges[edge.Source], edge)
+			incomingEdges[edge.Target] = append(incomingEdges[edge.Target], edge)
+		}
+	}
+
+	// sources start the pipeline and feed a single sequence, sinks end it
+	for _, node := range nodes {
+		if IsSource(node) {
+			for _, edge := range incomingEdges[node.ID] {
+				errs = append(errs, model.ValidationError{
+					Code:    SourceInput,
+					Message: fmt.Sprintf("edge %s points into source node %s", edge.ID, node.ID),
+					EdgeID:  edge.ID,
+				})
+			}
+			outgoing := outgoingEdges[node.ID]
+			if len(outgoing) != 1 || sinkIds[outgoing[0].Target] {
+				errs = append(errs, model.ValidationError{
+					Code:    SourceOutput,
+					Message: fmt.Sprintf("source node %s must be connected to exactly one function", node.ID),
+					NodeID:  node.ID,
+				})
+			}
+		}
+		if IsSink(node) {
+			for _, edge := range outgoingEdges[node.ID] {
+				errs = append(errs, model.ValidationError{
+					Code:    SinkOutput,
+					Message: fmt.Sprintf("edge %s leaves sink node %s", edge.ID, node.ID),
+					EdgeID:  edge.ID,
+				})
+			}
+			if len(incomingEdges[node.ID]) == 0 {
+				errs = append(errs, model.ValidationError{
+					Code:    SinkInput,
+					Message: fmt.Sprintf("sink node %s is not connected to any node", node.ID),
+					NodeID:  node.ID,
+				})
+			}
+		}
+	}
+
+	errs = append(errs, validateConditions(nodes, outgoingEdges)...)
+	return append(errs, findCycles(nodes, outgoingEdges)...)
+}
+
+// ValidateRoot checks that exactly one function has no function before it, as the events sent to a pipeline enter
+// through that one and any other would never get them. Only sources may point at it. A graph whose functions all
+// have one before them has a cycle, which ValidateGraph reports.
+func ValidateRoot(nodes []model.Node, edges []model.Edge) []model.ValidationError {
+	functionIds := map[string]bool{}
+	sourceIds := map[string]bool{}
+	for _, node := range nodes {
+		if IsSource(node) {
+			sourceIds[node.ID] = true
+		} else if !IsSink(node) {
+			functionIds[node.ID] = true
+		}
+	}
+	if len(functionIds) == 0 {
+		return []model.ValidationError{{
+			Code:    InvalidRoot,
+			Message: "the graph has no function to send the events to",
+		}}
+	}
+
+	hasPredecessor := map[string]bool{}
+	for _, edge := range edges {
+		if !sourceIds[edge.Source] {
+			hasPredecessor[edge.Target] = true
+		}
+	}
+	roots := []string{}
+	for _, node := range nodes {
+		if functionIds[node.ID] && !hasPredecessor[node.ID] {
+			roots = append(roots, node.ID)
+			// a duplicate id is reported once, by ValidateGraph
+			hasPredecessor[node.ID] = true
+		}
+	}
+
+	errs := []model.ValidationError{}
+	if len(roots) > 1 {
+		for _, nodeId := range roots {
+			errs = append(errs, model.ValidationError{
+				Code:    InvalidRoot,
+				Message: fmt.Sprintf("node %s is one of %d functions without one before them, the pipeline can only be entered through one", nodeId, len(roots)),
+				NodeID:  nodeId,
+			})
+		}
+	}
+	return errs
+}
+
+// findCycles walks the graph depth first and reports every edge that leads back into the current path
+func findCycles(nodes []model.Node, outgoingEdges map[string][]model.Edge) []model.ValidationError {
+	errs := []model.ValidationError{}
+
+	const (
+		unvisited = iota
+		inPath
+		done
+	)
+	state := make(map[string]int)
+
+	var visit func(nodeId string)
+	visit = func(nodeId string) {
+		state[nodeId] = inPath
+		for _, edge := range outgoingEdges[nodeId] {
+			switch state[edge.Target] {
+			case inPath:
+				errs = append(errs, model.ValidationError{
+					Code:    Cycle,
+					Message: fmt.Sprintf("edge %s from %s to %s creates a cycle", edge.ID, edge.Source, edge.Target),
+					EdgeID:  edge.ID,
+				})
+			case unvisited:
+				visit(edge.Target)
+			}
+		}
+		state[nodeId] = done
+	}
+
+	for _, node := range nodes {
+		if state[node.ID] == unvisited {
+			visit(node.ID)
+		}
+	}
+	return errs
+}
+
+// validateSource checks that a source node carries the configuration its type needs
+func validateSource(node model.Node) []model.ValidationError {
+	missing := ""
+	switch node.Type {
+	case model.ApiServerSourceNode:
+		if node.Data.ApiServerSource == nil || len(node.Data.ApiServerSource.Resources) == 0 {
+			missing = "list of resources to watch"
+		}
+	case model.PingSourceNode:
+		if node.Data.PingSource == nil || node.Data.PingSource.Schedule == "" {
+			missing = "schedule"
+		}
+	case model.ContainerSourceNode:
+		if node.Data.ContainerSource == nil || node.Data.ContainerSource.Image == "" {
+			missing = "container image"
+		}
+	case model.SinkBindingNode:
+		binding := node.Data.SinkBinding
+		if binding == nil || binding.APIVersion == "" || binding.Kind == "" || (binding.Name == "" && len(binding.Selector) == 0) {
+			missing = "subject to bind"
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+	return []model.ValidationError{{
+		Code:    InvalidSource,
+		Message: fmt.Sprintf("%s node %s needs a %s", node.Type, node.ID, missing),
+		NodeID:  node.ID,
+	}}
+}
+
+// validateSink checks that a sink node names where the output goes
+func validateSink(node model.Node) []model.ValidationError {
+	sink := node.Data.Sink
+	message := ""
+	switch {
+	case node.Type == model.URINode && (sink == nil || !isAbsoluteURI(sink.URI)):
+		message = fmt.Sprintf("uri node %s needs an absolute uri", node.ID)
+	case node.Type != model.URINode && (sink == nil || sink.Name == ""):
+		message = fmt.Sprintf("%s node %s needs a name", node.Type, node.ID)
+	default:
+		return nil
+	}
+	return []model.ValidationError{{
+		Code:    InvalidSink,
+		Message: message,
+		NodeID:  node.ID,
+	}}
+}
+
+// validateConditions checks that conditions only sit on edges leaving a fork and that each can become a branch filter
+func validateConditions(nodes []model.Node, outgoingEdges map[string][]model.Edge) []model.ValidationError {
+	errs := []model.ValidationError{}
+	invalid := func(edge model.Edge, message string) {
+		errs = append(errs, model.ValidationError{
+			Code:    InvalidCondition,
+			Message: fmt.Sprintf("condition on edge %s %s", edge.ID, message),
+			EdgeID:  edge.ID,
+		})
+	}
+
+	checked := make(map[string]bool)
+	for _, node := range nodes {
+		if checked[node.ID] {
+			continue
+		}
+		checked[node.ID] = true
+
+		edges := outgoingEdges[node.ID]
+		otherwise, functions := 0, 0
+		for _, edge := range edges {
+			condition := edge.Condition
+			if condition == nil {
+				continue
+			}
+			if len(edges) < 2 {
+				invalid(edge, "is not on a branch of a fork")
+				continue
+			}
+
+			set := 0
+			if len(condition.Attributes) > 0 {
+				set++
+			}
+			if condition.FaasID != "" {
+				set++
+				functions++
+			}
+			if condition.Otherwise {
+				set++
+				otherwise++
+			}
+			if set != 1 {
+				invalid(edge, "must set exactly one of attributes, faasId or otherwise")
+			}
+			if otherwise > 1 && condition.Otherwise {
+				invalid(edge, "is a second otherwise branch of the same fork")
+			}
+		}
+		// otherwise is the negation of the attribute conditions, a filter function can not be negated
+		if otherwise > 0 && functions > 0 {
+			for _, edge := range edges {
+				if edge.Condition != nil && edge.Condition.Otherwise {
+					invalid(edge, "can not negate the filter function on another branch")
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func isAbsoluteURI(value string) bool {
+	uri, err := url.Parse(value)
+	return err == nil && uri.IsAbs() && uri.Host != ""
+}
@@ -1,5 +1,32 @@
 package model
 
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
+	flows "knative.dev/eventing/pkg/apis/flows/v1"
+)
+
+// Node types, a node without one of these types runs the function in its FaasID
+const (
+	ApiServerSourceNode = "apiserversource"
+	PingSourceNode      = "pingsource"
+	ContainerSourceNode = "containersource"
+	SinkBindingNode     = "sinkbinding"
+
+	BrokerNode  = "broker"
+	ChannelNode = "channel"
+	ServiceNode = "service"
+	URINode     = "uri"
+)
+
+// Backends a graph can be translated with
+const (
+	// FlowsBackend chains the functions with sequences and parallels, the default
+	FlowsBackend = "flows"
+	// BrokerBackend routes events between the functions through one broker with a trigger per edge
+	BrokerBackend = "broker"
+)
+
 // Node represents a single node in the pipeline
 type Node struct {
 	ID         string   `json:"id"`
@@ -13,6 +40,83 @@ type Node struct {
 type NodeData struct {
 	Label  string `json:"label"`
 	FaasID string `json:"faasId,omitempty"` // Optional field
+
+	// Source configuration, only the one matching the node type is used
+	ApiServerSource *ApiServerSourceConfig `json:"apiServerSource,omitempty"`
+	PingSource      *PingSourceConfig      `json:"pingSource,omitempty"`
+	ContainerSource *ContainerSourceConfig `json:"containerSource,omitempty"`
+	SinkBinding     *SinkBindingConfig     `json:"sinkBinding,omitempty"`
+
+	// Sink configuration for broker, channel, service and uri nodes
+	Sink *SinkConfig `json:"sink,omitempty"`
+
+	// Delivery overrides the pipeline delivery settings for this node
+	Delivery *DeliveryConfig `json:"delivery,omitempty"`
+}
+
+// DeliveryConfig controls how failed deliveries are retried and where events go once the retries run out
+type DeliveryConfig struct {
+	Retry          *int32            `json:"retry,omitempty"`
+	BackoffPolicy  string            `json:"backoffPolicy,omitempty"` // linear or exponential
+	BackoffDelay   string            `json:"backoffDelay,omitempty"`  // ISO 8601 duration, e.g. PT0.5S
+	Timeout        string            `json:"timeout,omitempty"`       // ISO 8601 duration
+	DeadLetterSink *DeadLetterConfig `json:"deadLetterSink,omitempty"`
+}
+
+// ChannelConfig picks the channel implementation behind the sequences and parallels, InMemoryChannel when not set
+type ChannelConfig struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Spec       map[string]interface{} `json:"spec,omitempty"` // e.g. numPartitions and replicationFactor for a KafkaChannel
+}
+
+// DeadLetterConfig is where undeliverable events end up, Type is one of the sink node types
+type DeadLetterConfig struct {
+	Type string `json:"type"`
+	SinkConfig
+}
+
+// SinkConfig names where the output of the pipeline is delivered
+type SinkConfig struct {
+	Name string `json:"name,omitempty"` // The broker, channel or service in the pipeline namespace
+	URI  string `json:"uri,omitempty"`  // An absolute URI outside the cluster
+}
+
+// ApiServerSourceConfig configures a source that turns kubernetes events into cloud events
+type ApiServerSourceConfig struct {
+	Resources          []SourceResource `json:"resources"`
+	Mode               string           `json:"mode,omitempty"` // Reference or Resource, defaults to Reference
+	ServiceAccountName string           `json:"serviceAccountName,omitempty"`
+}
+
+// SourceResource selects the kubernetes resources an api server source watches
+type SourceResource struct {
+	APIVersion    string            `json:"apiVersion"`
+	Kind          string            `json:"kind"`
+	LabelSelector map[string]string `json:"labelSelector,omitempty"`
+}
+
+// PingSourceConfig configures a source that sends the same event on a cron schedule
+type PingSourceConfig struct {
+	Schedule    string `json:"schedule"`
+	Timezone    string `json:"timezone,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Data        string `json:"data,omitempty"`
+}
+
+// ContainerSourceConfig configures a source that runs an image which sends events to the pipeline
+type ContainerSourceConfig struct {
+	Image string            `json:"image"`
+	Args  []string          `json:"args,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
+// SinkBindingConfig configures a binding that points an existing workload at the pipeline
+type SinkBindingConfig struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Name       string            `json:"name,omitempty"` // Either the name or the selector picks the workload
+	Selector   map[string]string `json:"selector,omitempty"`
 }
 
 // Position represents the position of a node
@@ -23,13 +127,164 @@ type Position struct {
 
 // Edge represents an edge between two nodes
 type Edge struct {
-	ID     string `json:"id"`
-	Source string `json:"source"`
-	Target string `json:"target"`
+	ID        string         `json:"id"`
+	Source    string         `json:"source"`
+	Target    string         `json:"target"`
+	Condition *EdgeCondition `json:"condition,omitempty"` // Optional field, only on edges leaving a fork
+}
+
+// EdgeCondition limits the events that follow an edge leaving a fork, exactly one field is set
+type EdgeCondition struct {
+	Attributes map[string]string `json:"attributes,omitempty"` // CloudEvent attributes that must all match, e.g. type: order.created
+	FaasID     string            `json:"faasId,omitempty"`     // A filter function, the events it returns follow the edge
+	Otherwise  bool              `json:"otherwise,omitempty"`  // Events matching none of the attribute conditions on the other edges of the fork
 }
 
 // PipelinePayload represents the full payload for the /pipeline endpoint
 type PipelinePayload struct {
-	Nodes []Node `json:"nodes"`
-	Edges []Edge `json:"edges"`
+	Name      string          `json:"name,omitempty"`      // Optional field, the pipeline id is derived from the graph without it
+	Namespace string          `json:"namespace,omitempty"` // Optional field, the namespace query parameter or "default" is used without it
+	Delivery  *DeliveryConfig `json:"delivery,omitempty"`  // Optional field, applies to every node unless the node overrides it
+	Channel   *ChannelConfig  `json:"channel,omitempty"`   // Optional field, the server default channel is used without it
+	FilterURI string          `json:"filterUri,omitempty"` // Optional field, the service evaluating attribute conditions, the server default is used without it
+	StepURI   string          `json:"stepUri,omitempty"`   // Optional field, the service relaying calls to functions with the broker backend, the server default is used without it
+	Backend   string          `json:"backend,omitempty"`   // Optional field, "flows" or "broker", "flows" is used without it
+	Nodes     []Node          `json:"nodes"`
+	Edges     []Edge          `json:"edges"`
+}
+
+// ValidationError represents a single problem found in the submitted graph
+type ValidationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	NodeID  string `json:"nodeId,omitempty"` // Set when the problem is with a node
+	EdgeID  string `json:"edgeId,omitempty"` // Set when the problem is with an edge
+}
+
+// Pipeline represents a deployed pipeline and the knative resources that belong to it
+type Pipeline struct {
+	ID        string                      `json:"id"`
+	Name      string                      `json:"name,omitempty"`
+	Delivery  *DeliveryConfig             `json:"delivery,omitempty"`
+	Channel   *ChannelConfig              `json:"channel,omitempty"`
+	Backend   string                      `json:"backend,omitempty"`
+	Nodes     []Node                      `json:"nodes"`
+	Edges     []Edge                      `json:"edges"`
+	Sequences []flows.Sequence            `json:"sequences"`
+	Parallels []flows.Parallel            `json:"parallels"`
+	Brokers   []eventing.Broker           `json:"brokers,omitempty"`   // Only with the broker backend
+	Triggers  []eventing.Trigger          `json:"triggers,omitempty"`  // Only with the broker backend, one per edge
+	Sources   []unstructured.Unstructured `json:"sources,omitempty"`   // Knative sources generated from the source nodes
+	Entry     *PipelineEntry              `json:"entry,omitempty"`     // Where events should be sent to start the pipeline
+	Changes   *PipelineChanges            `json:"changes,omitempty"`   // Only set when the pipeline was just deployed
+	Workflows []string                    `json:"workflows,omitempty"` // Argo WorkflowTemplates exported from the pipeline and applied
+	// Objects holds every resource of the pipeline whatever its kind, the fields above are views of the kinds they name
+	Objects []unstructured.Unstructured `json:"-"`
+}
+
+// PipelineEntry represents the resource that events enter the pipeline through
+type PipelineEntry struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"` // Filled in once knative has made the resource addressable
+}
+
+// PipelineResources lists the resources generated for a pipeline
+type PipelineResources struct {
+	Sequences []string          `json:"sequences"`
+	Parallels []string          `json:"parallels"`
+	Sources   []string          `json:"sources,omitempty"`
+	Brokers   []string          `json:"brokers,omitempty"`
+	Triggers  []string          `json:"triggers,omitempty"`
+	Others    []string          `json:"others,omitempty"` // Kind/name of the resources of any other kind
+	Nodes     map[string]string `json:"nodes"`            // Node id to the sequence or broker the node runs behind, or the source it became
+}
+
+// PipelineChanges lists the resources touched by a deploy
+type PipelineChanges struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+}
+
+// PipelinePreview represents the resources a pipeline would create, without creating them
+type PipelinePreview struct {
+	Pipeline
+	Manifests    string          `json:"manifests,omitempty"`    // YAML rendering of the resources when requested
+	DryRunErrors []ResourceError `json:"dryRunErrors,omitempty"` // Only set for a server side dry run
+}
+
+// WorkflowExport represents the graph as a DAG for a workflow engine, to run it as a job rather than an event flow
+type WorkflowExport struct {
+	ID       string                    `json:"id"`
+	Mode     string                    `json:"mode"`               // http or container, how the tasks run the functions
+	Workflow unstructured.Unstructured `json:"workflow"`           // An argo WorkflowTemplate with one task per function
+	Manifest string                    `json:"manifest,omitempty"` // YAML rendering of the workflow when requested
+	Applied  bool                      `json:"applied"`            // Whether the workflow was created or updated in the cluster
+}
+
+// ExecutionEvent is a CloudEvent as the nodes of a pipeline receive and reply it
+type ExecutionEvent struct {
+	ID          string            `json:"id"`
+	Source      string            `json:"source"`
+	Type        string            `json:"type"`
+	Subject     string            `json:"subject,omitempty"`
+	ContentType string            `json:"datacontenttype,omitempty"`
+	Extensions  map[string]string `json:"extensions,omitempty"`
+	Data        string            `json:"data,omitempty"`
+}
+
+// ExecutionStep records a single call made by a local execution
+type ExecutionStep struct {
+	NodeID string          `json:"nodeId"`
+	Filter string          `json:"filter,omitempty"` // The filter function when the call decided whether the node gets the event
+	URL    string          `json:"url"`
+	Input  ExecutionEvent  `json:"input"`
+	Output *ExecutionEvent `json:"output,omitempty"` // Not set when the node failed or replied without an event
+	Status int             `json:"status,omitempty"` // HTTP status of the reply, not set when the call failed
+	Error  string          `json:"error,omitempty"`
+}
+
+// Execution is the outcome of running a graph locally
+type Execution struct {
+	Steps   []ExecutionStep             `json:"steps"`   // Every call in the order it was made
+	Outputs map[string][]ExecutionEvent `json:"outputs"` // Events leaving the pipeline, by the sink or last node they left through
+}
+
+// ResourceError represents a problem with a single generated resource
+type ResourceError struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// PipelineStatus reports whether every resource behind a pipeline is ready
+type PipelineStatus struct {
+	ID        string                `json:"id"`
+	Ready     bool                  `json:"ready"`
+	Entry     *PipelineEntry        `json:"entry,omitempty"`
+	Resources []ResourceStatus      `json:"resources"`
+	Nodes     map[string]NodeStatus `json:"nodes"`
+	// ReadyResources counts the resources that are ready, out of all of them
+	ReadyResources int `json:"readyResources"`
+	// Blocking lists the resources that are not ready, with the conditions holding them back
+	Blocking []ResourceStatus `json:"blocking,omitempty"`
+}
+
+// ResourceStatus is the readiness of a single generated resource, with the reasons it is not ready
+type ResourceStatus struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// NodeStatus is the health of a node, taken from its service and the sequence it runs in
+type NodeStatus struct {
+	Ready    bool     `json:"ready"`
+	Source   string   `json:"source,omitempty"`
+	Sequence string   `json:"sequence,omitempty"`
+	Triggers []string `json:"triggers,omitempty"` // The triggers delivering to the node, with the broker backend
+	Service  string   `json:"service,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
 }
@@ -16,6 +16,7 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
 	flows "knative.dev/eventing/pkg/apis/flows/v1"
 	messaging "knative.dev/eventing/pkg/apis/messaging/v1"
 	duck "knative.dev/pkg/apis/duck/v1"
@@ -24,7 +25,7 @@ import (
 
 var (
 	cfg       *rest.Config
-	k8sClient client.Client
+	k8sClient client.WithWatch
 	testEnv   *envtest.Environment
 	namespace = "knative"
 )
@@ -64,12 +65,15 @@ var _ = BeforeSuite(func() {
 	err = messaging.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = eventing.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	err = duck.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
-	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	k8sClient, err = client.NewWithWatch(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
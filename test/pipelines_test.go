@@ -2,7 +2,15 @@ package main_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -12,12 +20,20 @@ import (
 	"aaaas/pipeline-api/pkg/api/helpers"
 	"aaaas/pipeline-api/pkg/api/model"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 
+	eventingduck "knative.dev/eventing/pkg/apis/duck/v1"
+	eventing "knative.dev/eventing/pkg/apis/eventing/v1"
 	flows "knative.dev/eventing/pkg/apis/flows/v1"
 	messaging "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/apis"
 	duck "knative.dev/pkg/apis/duck/v1"
 	serving "knative.dev/serving/pkg/apis/serving/v1"
 )
@@ -39,6 +55,10 @@ var _ = Describe("Pipelines", func() {
 		Expect(deleteAllKsvc(ctx)).To(Succeed())
 		Expect(deleteAllSequences(ctx)).To(Succeed())
 		Expect(deleteAllParallels(ctx)).To(Succeed())
+		Expect(deleteAllSources(ctx)).To(Succeed())
+		Expect(deleteAllBrokers(ctx)).To(Succeed())
+		Expect(deleteAllWorkflows(ctx)).To(Succeed())
+		Expect(deleteAllGraphs(ctx)).To(Succeed())
 	})
 
 	Context("when verifying the startup environment", func() {
@@ -152,10 +172,14 @@ var _ = Describe("Pipelines", func() {
 				0 has childs [1,2]
 				1 has childs [4,5]
 
+				6 is a join so it gets a sequence of its own that both 3 and 5 reply into
 				the sequences should be
+				0
+				1
+				2 -> 3
 				4
-				5 -> 6
-				2 -> 3 -> 6
+				5
+				6
 			*/
 			pipelinePayload := model.PipelinePayload{
 				Nodes: []model.Node{
@@ -184,9 +208,12 @@ var _ = Describe("Pipelines", func() {
 					"1": {"4", "5"},
 				},
 				"sequences": [][]string{
-					{"2", "3", "6"},
+					{"0"},
+					{"1"},
+					{"2", "3"},
 					{"4"},
-					{"5", "6"},
+					{"5"},
+					{"6"},
 				},
 			}
 
@@ -209,7 +236,7 @@ var _ = Describe("Pipelines", func() {
 				1: [3,4]
 				2: [5,6]
 
-				3, 4, 5, 6 should be a single node sequence
+				every node should be a single node sequence
 			*/
 			pipelinePayload := model.PipelinePayload{
 				Nodes: []model.Node{
@@ -238,6 +265,9 @@ var _ = Describe("Pipelines", func() {
 					"2": {"5", "6"},
 				},
 				"sequences": [][]string{
+					{"0"},
+					{"1"},
+					{"2"},
 					{"3"},
 					{"4"},
 					{"5"},
@@ -316,6 +346,160 @@ var _ = Describe("Pipelines", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(len(validNodes)).To(BeEquivalentTo(0))
 		})
+		It("should report every problem in an invalid graph", func() {
+			/*
+				0 -> 1 -> 2
+				^         |
+				|_________|
+
+				2 has no function, 1 -> 1 loops on itself, 1 -> 2 is duplicated and 2 -> 9 points nowhere
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "FaaS 0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "FaaS 1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "FaaS 2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-1", Source: "1", Target: "1"},
+					{ID: "1-2", Source: "1", Target: "2"},
+					{ID: "1-2b", Source: "1", Target: "2"},
+					{ID: "2-0", Source: "2", Target: "0"},
+					{ID: "2-9", Source: "2", Target: "9"},
+				},
+			}
+
+			validationErrs := helpers.ValidateGraph(pipelinePayload.Nodes, pipelinePayload.Edges)
+			Expect(validationErrs).To(ConsistOf(
+				model.ValidationError{Code: helpers.MissingFaasID, Message: "node 2 is not mapped to a function", NodeID: "2"},
+				model.ValidationError{Code: helpers.SelfLoop, Message: "edge 1-1 connects node 1 to itself", EdgeID: "1-1"},
+				model.ValidationError{Code: helpers.DuplicateEdge, Message: "edge 1-2b duplicates another edge from 1 to 2", EdgeID: "1-2b"},
+				model.ValidationError{Code: helpers.UnknownNode, Message: "edge 2-9 points to unknown node 9", EdgeID: "2-9"},
+				model.ValidationError{Code: helpers.Cycle, Message: "edge 2-0 from 2 to 0 creates a cycle", EdgeID: "2-0"},
+			))
+
+			// nothing should be deployed
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(5))
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(0))
+		})
+
+		It("should reject duplicate node ids", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "FaaS 0", FaasID: "func-0"}},
+					{ID: "0", Data: model.NodeData{Label: "FaaS 1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{},
+			}
+
+			validationErrs := helpers.ValidateGraph(pipelinePayload.Nodes, pipelinePayload.Edges)
+			Expect(validationErrs).To(HaveLen(1))
+			Expect(validationErrs[0].Code).To(BeEquivalentTo(helpers.DuplicateNode))
+			Expect(validationErrs[0].NodeID).To(BeEquivalentTo("0"))
+		})
+
+		It("should reject a graph that does not have exactly one root function", func() {
+			/*
+				ping -> 0 -> 2
+				        1 -> 2
+
+				the ping source points at 0, but 1 has no function before it either
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "ping", Type: model.PingSourceNode, Data: model.NodeData{Label: "Ping", PingSource: &model.PingSourceConfig{Schedule: "*/1 * * * *"}}},
+					{ID: "0", Data: model.NodeData{Label: "FaaS 0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "FaaS 1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "FaaS 2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "ping-0", Source: "ping", Target: "0"},
+					{ID: "0-2", Source: "0", Target: "2"},
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+
+			Expect(helpers.ValidateGraph(pipelinePayload.Nodes, pipelinePayload.Edges)).To(BeEmpty())
+			validationErrs := helpers.ValidateRoot(pipelinePayload.Nodes, pipelinePayload.Edges)
+			Expect(validationErrs).To(HaveLen(2))
+			Expect(validationErrs[0].Code).To(BeEquivalentTo(helpers.InvalidRoot))
+			Expect(validationErrs[0].NodeID).To(BeEquivalentTo("0"))
+			Expect(validationErrs[1].NodeID).To(BeEquivalentTo("1"))
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(Equal(validationErrs))
+
+			// a graph of sources and sinks only has nothing to enter through
+			validationErrs = helpers.ValidateRoot(pipelinePayload.Nodes[:1], nil)
+			Expect(validationErrs).To(HaveLen(1))
+			Expect(validationErrs[0].Code).To(BeEquivalentTo(helpers.InvalidRoot))
+		})
+
+		It("should check source nodes instead of mapping them to functions", func() {
+			/*
+				ping -> 0 -> cron
+				api
+
+				the api source has no configuration and no output, the cron source has an input
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "ping", Type: model.PingSourceNode, Data: model.NodeData{Label: "Ping", PingSource: &model.PingSourceConfig{Schedule: "*/1 * * * *"}}},
+					{ID: "api", Type: model.ApiServerSourceNode, Data: model.NodeData{Label: "API Server Source"}},
+					{ID: "cron", Type: model.PingSourceNode, Data: model.NodeData{Label: "Cron", PingSource: &model.PingSourceConfig{Schedule: "0 * * * *"}}},
+					{ID: "0", Data: model.NodeData{Label: "FaaS 0", FaasID: "func-0"}},
+				},
+				Edges: []model.Edge{
+					{ID: "ping-0", Source: "ping", Target: "0"},
+					{ID: "0-cron", Source: "0", Target: "cron"},
+				},
+			}
+
+			validationErrs := helpers.ValidateGraph(pipelinePayload.Nodes, pipelinePayload.Edges)
+			Expect(validationErrs).To(ConsistOf(
+				model.ValidationError{Code: helpers.InvalidSource, Message: "apiserversource node api needs a list of resources to watch", NodeID: "api"},
+				model.ValidationError{Code: helpers.SourceOutput, Message: "source node api must be connected to exactly one function", NodeID: "api"},
+				model.ValidationError{Code: helpers.SourceInput, Message: "edge 0-cron points into source node cron", EdgeID: "0-cron"},
+				model.ValidationError{Code: helpers.SourceOutput, Message: "source node cron must be connected to exactly one function", NodeID: "cron"},
+			))
+		})
+
+		It("should check sink nodes instead of mapping them to functions", func() {
+			/*
+				0 -> web -> 1
+				broker
+
+				the broker has no name and no input, the uri sink has an output
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "FaaS 0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "FaaS 1", FaasID: "func-1"}},
+					{ID: "web", Type: model.URINode, Data: model.NodeData{Label: "Web", Sink: &model.SinkConfig{URI: "https://example.com/events"}}},
+					{ID: "broker", Type: model.BrokerNode, Data: model.NodeData{Label: "Broker"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-web", Source: "0", Target: "web"},
+					{ID: "web-1", Source: "web", Target: "1"},
+				},
+			}
+
+			validationErrs := helpers.ValidateGraph(pipelinePayload.Nodes, pipelinePayload.Edges)
+			Expect(validationErrs).To(ConsistOf(
+				model.ValidationError{Code: helpers.InvalidSink, Message: "broker node broker needs a name", NodeID: "broker"},
+				model.ValidationError{Code: helpers.SinkInput, Message: "sink node broker is not connected to any node", NodeID: "broker"},
+				model.ValidationError{Code: helpers.SinkOutput, Message: "edge web-1 leaves sink node web", EdgeID: "web-1"},
+			))
+		})
 	})
 
 	Context("When managing knative sequences", func() {
@@ -364,14 +548,22 @@ var _ = Describe("Pipelines", func() {
 		})
 
 		It("should create the sequence in the cluster", func() {
-			validFaasIds := []string{"abc", "def", "ghi"}
-			sequenceName := "test-sequence"
-			sequence := handlers.TranslateSequence(validFaasIds, namespace, sequenceName)
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
 
-			err := handlers.ApplySequence(ctx, k8sClient, sequence)
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
 			Expect(err).NotTo(HaveOccurred())
 
-			createdSequence, err := getSequence(ctx, sequenceName)
+			createdSequence, err := getSequence(ctx, pipeline.Nodes[0].SequenceId)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(len(createdSequence.Spec.Steps)).To(BeEquivalentTo(3))
@@ -440,7 +632,7 @@ var _ = Describe("Pipelines", func() {
 				},
 			}
 
-			err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
 			Expect(err).NotTo(HaveOccurred())
 
 			sequenceList, err := getSequenceList(ctx)
@@ -482,7 +674,7 @@ var _ = Describe("Pipelines", func() {
 				},
 			}
 
-			err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
 			Expect(err).NotTo(HaveOccurred())
 
 			sequenceList, err := getSequenceList(ctx)
@@ -517,10 +709,14 @@ var _ = Describe("Pipelines", func() {
 				0 has childs [1,2]
 				1 has childs [4,5]
 
+				6 is a join so it gets a sequence of its own that both 3 and 5 reply into
 				the sequences should be
+				0
+				1
+				2 -> 3
 				4
-				5 -> 6
-				2 -> 3 -> 6
+				5
+				6
 			*/
 			pipelinePayload := model.PipelinePayload{
 				Nodes: []model.Node{
@@ -543,23 +739,38 @@ var _ = Describe("Pipelines", func() {
 				},
 			}
 
-			err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
 			Expect(err).NotTo(HaveOccurred())
 
 			sequenceList, err := getSequenceList(ctx)
 			Expect(err).NotTo(HaveOccurred())
 
-			//expect 3 sequence to be deployed
-			Expect(sequenceList.Items).To(HaveLen(3))
+			//expect 6 sequence to be deployed, every node is deployed exactly once
+			Expect(sequenceList.Items).To(HaveLen(6))
+			steps := 0
+			for _, sequence := range sequenceList.Items {
+				steps += len(sequence.Spec.Steps)
+			}
+			Expect(steps).To(BeEquivalentTo(7))
 
 			// check that the sequence starts are labelled correctly
+			Expect(pipelinePayload.Nodes[0].SequenceId).NotTo(BeEquivalentTo(""))
+			Expect(pipelinePayload.Nodes[1].SequenceId).NotTo(BeEquivalentTo(""))
 			Expect(pipelinePayload.Nodes[2].SequenceId).NotTo(BeEquivalentTo(""))
 			Expect(pipelinePayload.Nodes[4].SequenceId).NotTo(BeEquivalentTo(""))
 			Expect(pipelinePayload.Nodes[5].SequenceId).NotTo(BeEquivalentTo(""))
+			Expect(pipelinePayload.Nodes[6].SequenceId).NotTo(BeEquivalentTo(""))
 
-			Expect(pipelinePayload.Nodes[1].SequenceId).To(BeEquivalentTo(""))
 			Expect(pipelinePayload.Nodes[3].SequenceId).To(BeEquivalentTo(""))
-			Expect(pipelinePayload.Nodes[6].SequenceId).To(BeEquivalentTo(""))
+
+			// both upstream branches reply into the join
+			joinSequence := pipelinePayload.Nodes[6].SequenceId
+			for _, nodeIndex := range []int{2, 5} {
+				sequence, err := getSequence(ctx, pipelinePayload.Nodes[nodeIndex].SequenceId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sequence.Spec.Reply.Ref.Kind).To(BeEquivalentTo("Sequence"))
+				Expect(sequence.Spec.Reply.Ref.Name).To(BeEquivalentTo(joinSequence))
+			}
 
 			// check that there are 2 parallels
 			parallelList, err := getParallelList(ctx)
@@ -581,7 +792,7 @@ var _ = Describe("Pipelines", func() {
 				1: [3,4]
 				2: [5,6]
 
-				3, 4, 5, 6 should be a single node sequence
+				every node should be a single node sequence
 			*/
 			pipelinePayload := model.PipelinePayload{
 				Nodes: []model.Node{
@@ -603,17 +814,16 @@ var _ = Describe("Pipelines", func() {
 				},
 			}
 
-			err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
 			Expect(err).NotTo(HaveOccurred())
 
 			sequenceList, err := getSequenceList(ctx)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(len(sequenceList.Items)).To(BeEquivalentTo(4))
-			Expect(len(sequenceList.Items[0].Spec.Steps)).To(BeEquivalentTo(1))
-			Expect(len(sequenceList.Items[1].Spec.Steps)).To(BeEquivalentTo(1))
-			Expect(len(sequenceList.Items[2].Spec.Steps)).To(BeEquivalentTo(1))
-			Expect(len(sequenceList.Items[3].Spec.Steps)).To(BeEquivalentTo(1))
+			Expect(len(sequenceList.Items)).To(BeEquivalentTo(7))
+			for _, sequence := range sequenceList.Items {
+				Expect(len(sequence.Spec.Steps)).To(BeEquivalentTo(1))
+			}
 
 			// check that there are 3 parallels
 			parallelList, err := getParallelList(ctx)
@@ -622,135 +832,2288 @@ var _ = Describe("Pipelines", func() {
 			Expect(parallelList.Items).To(HaveLen(3))
 		})
 	})
-})
-
-func createKsvc(ctx context.Context, funcName string) error {
-	ksvc := &serving.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      funcName,
-			Namespace: namespace,
-		},
-		Spec: serving.ServiceSpec{
-			ConfigurationSpec: serving.ConfigurationSpec{
-				Template: serving.RevisionTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{},
-					Spec: serving.RevisionSpec{
-						PodSpec: v1.PodSpec{
-							Containers: []v1.Container{},
-						},
-					},
+	Context("When managing deployed pipelines", func() {
+		It("should get, list and delete a deployed pipeline", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2 -> 3
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
 				},
-			},
-		},
-	}
-	return k8sClient.Create(ctx, ksvc)
-}
-
-func deleteKsvc(ctx context.Context, funcName string) error {
-	ksvc := &serving.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      funcName,
-			Namespace: namespace,
-		},
-		Spec: serving.ServiceSpec{
-			ConfigurationSpec: serving.ConfigurationSpec{
-				Template: serving.RevisionTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{},
-					Spec: serving.RevisionSpec{
-						PodSpec: v1.PodSpec{
-							Containers: []v1.Container{},
-						},
-					},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+					{ID: "2-3", Source: "2", Target: "3"},
 				},
-			},
-		},
-	}
-	return k8sClient.Delete(ctx, ksvc)
-}
+			}
 
-func getKsvc(ctx context.Context, funcName string) (*serving.Service, error) {
-	ksvc := &serving.Service{}
-	typeNamespacedName := types.NamespacedName{
-		Name:      funcName,
-		Namespace: namespace,
-	}
-	err := k8sClient.Get(ctx, typeNamespacedName, ksvc)
-	return ksvc, err
-}
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployed.ID).NotTo(BeEmpty())
 
-func getSequence(ctx context.Context, sequenceName string) (*flows.Sequence, error) {
-	ksequence := &flows.Sequence{}
-	typeNamespacedName := types.NamespacedName{
-		Name:      sequenceName,
-		Namespace: namespace,
-	}
-	err := k8sClient.Get(ctx, typeNamespacedName, ksequence)
-	return ksequence, err
-}
+			pipeline, err := handlers.GetPipeline(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Sequences).To(HaveLen(3))
+			Expect(pipeline.Parallels).To(HaveLen(1))
+			Expect(pipeline.Edges).To(ContainElements(
+				model.Edge{ID: "0-1", Source: "0", Target: "1"},
+				model.Edge{ID: "0-2", Source: "0", Target: "2"},
+				model.Edge{ID: "2-3", Source: "2", Target: "3"},
+			))
+
+			pipelines, err := handlers.ListPipelines(ctx, k8sClient, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipelines).To(HaveLen(1))
+			Expect(pipelines[0].ID).To(BeEquivalentTo(deployed.ID))
 
-func getSequenceList(ctx context.Context) (*flows.SequenceList, error) {
-	ksequenceList := &flows.SequenceList{}
-	err := k8sClient.List(ctx, ksequenceList)
-	return ksequenceList, err
-}
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, deployed.ID)).To(Succeed())
 
-func getParallelList(ctx context.Context) (*flows.ParallelList, error) {
-	kparallelList := &flows.ParallelList{}
-	err := k8sClient.List(ctx, kparallelList)
-	return kparallelList, err
-}
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(0))
 
-func deleteAllKsvc(ctx context.Context) error {
-	for _, faasId := range testFaasList{
-		if err := deleteKsvc(ctx, faasId); err != nil {
-			return fmt.Errorf("failed to delete faas %s in namespace %s: %w",faasId, namespace, err)
-		}
-	}
-	return nil
-}
+			parallelList, err := getParallelList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parallelList.Items).To(HaveLen(0))
 
-func deleteAllSequences(ctx context.Context) error {
-	// Define a list to hold all Knative Sequences
-	sequenceList := &flows.SequenceList{}
-	// List all sequences in the given namespace
-	if err := k8sClient.List(ctx, sequenceList, client.InNamespace(namespace)); err != nil {
-		return fmt.Errorf("failed to list sequences in namespace %s: %w", namespace, err)
-	}
+			_, err = handlers.GetPipeline(ctx, k8sClient, namespace, deployed.ID)
+			Expect(errors.Is(err, handlers.ErrPipelineNotFound)).To(BeTrue())
+		})
+		It("should keep the submitted graph for the editor", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "Start", FaasID: "func-0"}, Position: model.Position{X: 10, Y: 20}, Type: "input"},
+					{ID: "1", Data: model.NodeData{Label: "End", FaasID: "func-1"}, Position: model.Position{X: 110, Y: 20}, Type: "output"},
+				},
+				Edges: []model.Edge{
+					{ID: "e0-1", Source: "0", Target: "1"},
+				},
+			}
 
-	// Iterate through each sequence and delete it
-	for _, sequence := range sequenceList.Items {
-		if err := k8sClient.Delete(ctx, &sequence); err != nil {
-			return fmt.Errorf("failed to delete sequence %s in namespace %s: %w", sequence.Name, namespace, err)
-		}
-	}
-	return nil
-}
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
 
-func deleteAllParallels(ctx context.Context) error {
-	// Define a list to hold all Knative parallels
-	parallelList := &flows.ParallelList{}
-	// List all parallels in the given namespace
-	if err := k8sClient.List(ctx, parallelList, client.InNamespace(namespace)); err != nil {
-		return fmt.Errorf("failed to list parallels in namespace %s: %w", namespace, err)
-	}
+			graph, err := handlers.GetPipelineGraph(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Nodes).To(BeEquivalentTo(pipelinePayload.Nodes))
+			Expect(graph.Edges).To(BeEquivalentTo(pipelinePayload.Edges))
 
-	// Iterate through each sequence and delete it
-	for _, parallel := range parallelList.Items {
-		if err := k8sClient.Delete(ctx, &parallel); err != nil {
-			return fmt.Errorf("failed to delete parallel %s in namespace %s: %w", parallel.Name, namespace, err)
-		}
-	}
-	return nil
-}
+			pipeline, err := handlers.GetPipeline(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Nodes[0].Position).To(BeEquivalentTo(model.Position{X: 10, Y: 20}))
+			Expect(pipeline.Nodes[1].Data.Label).To(BeEquivalentTo("End"))
 
-func getActualOutput(pipelinePayload model.PipelinePayload) map[string]interface{} {
-	nodes := pipelinePayload.Nodes
-	edges := pipelinePayload.Edges
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, deployed.ID)).To(Succeed())
+			_, err = handlers.GetPipelineGraph(ctx, k8sClient, namespace, deployed.ID)
+			Expect(errors.Is(err, handlers.ErrPipelineNotFound)).To(BeTrue())
+		})
+		It("should update a pipeline in place and prune removed resources", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
 
-	parallels, sequences := helpers.TraverseGraph(nodes, edges)
+				is updated to
 
-	return map[string]interface{}{
-		"parallels": parallels,
-		"sequences": sequences,
-	}
-}
\ No newline at end of file
+				1 -> 3
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployed.Sequences).To(HaveLen(3))
+			Expect(deployed.Parallels).To(HaveLen(1))
+
+			// the sequence starting at node 1 should be kept and extended
+			keptSequence := pipelinePayload.Nodes[1].SequenceId
+
+			updatedPayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-3", Source: "1", Target: "3"},
+				},
+			}
+
+			updated, err := handlers.UpdatePipeline(ctx, k8sClient, updatedPayload, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Changes.Updated).To(ContainElement(keptSequence))
+			Expect(updated.Changes.Deleted).To(HaveLen(3))
+
+			sequence, err := getSequence(ctx, keptSequence)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequence.Spec.Steps).To(HaveLen(2))
+			Expect(sequence.Spec.Steps[1].Destination.Ref.Name).To(BeEquivalentTo("func-3"))
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(1))
+
+			parallelList, err := getParallelList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parallelList.Items).To(HaveLen(0))
+
+			graph, err := handlers.GetPipelineGraph(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Edges).To(HaveLen(1))
+		})
+
+		It("should not replace a pipeline when a different graph is posted under its name", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "orders",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			// posting the same graph again is fine, the sequence ids the api set on the nodes aside
+			_, err = handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			otherPayload := model.PipelinePayload{
+				Name: "orders",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+			_, err = handlers.ProcessPayload(k8sClient, ctx, otherPayload, namespace)
+			Expect(errors.Is(err, handlers.ErrPipelineExists)).To(BeTrue())
+
+			graph, err := handlers.GetPipelineGraph(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Nodes[1].ID).To(BeEquivalentTo("1"))
+
+			// the new graph replaces the old one through an update
+			_, err = handlers.UpdatePipeline(ctx, k8sClient, otherPayload, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			graph, err = handlers.GetPipelineGraph(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Nodes[1].ID).To(BeEquivalentTo("2"))
+		})
+
+		It("should keep the annotations others set when updating a resource", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			branch, err := getSequence(ctx, pipelinePayload.Nodes[1].SequenceId)
+			Expect(err).NotTo(HaveOccurred())
+			branch.Annotations["example.com/team"] = "orders"
+			branch.Annotations[handlers.EntryAnnotation] = "true"
+			Expect(k8sClient.Update(ctx, branch)).To(Succeed())
+
+			redeployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(redeployed.Changes.Updated).To(ConsistOf(branch.Name))
+
+			branch, err = getSequence(ctx, branch.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(branch.Annotations).To(HaveKeyWithValue("example.com/team", "orders"))
+			Expect(branch.Annotations).NotTo(HaveKey(handlers.EntryAnnotation))
+			Expect(redeployed.Entry.Name).To(BeEquivalentTo(deployed.Entry.Name))
+		})
+
+		It("should not update a pipeline that does not exist", func() {
+			_, err := handlers.UpdatePipeline(ctx, k8sClient, model.PipelinePayload{}, namespace, "does-not-exist")
+			Expect(errors.Is(err, handlers.ErrPipelineNotFound)).To(BeTrue())
+		})
+		It("should roll back everything created when a deploy fails part way", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
+
+				the graph and the sequences are created before the parallel turns out to be taken
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipelineId := helpers.PipelineID("", pipelinePayload.Nodes, pipelinePayload.Edges)
+			conflicting := handlers.TranslateParallel([]string{"1", "2"}, namespace,
+				helpers.ResourceName(pipelineId, "parallel", "0"), pipelinePayload.Nodes)
+			Expect(k8sClient.Create(ctx, &conflicting)).To(Succeed())
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).To(HaveOccurred())
+
+			var deployErr *handlers.DeployError
+			Expect(errors.As(err, &deployErr)).To(BeTrue())
+			Expect(deployErr.RolledBack).To(HaveLen(4))
+			Expect(deployErr.NotRolledBack).To(BeEmpty())
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(0))
+
+			_, err = handlers.GetPipelineGraph(ctx, k8sClient, namespace, pipelineId)
+			Expect(errors.Is(err, handlers.ErrPipelineNotFound)).To(BeTrue())
+		})
+
+		It("should label and own every resource of a pipeline", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "owned",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			requestCtx := handlers.WithRequestInfo(ctx, handlers.RequestInfo{ID: "request-1", User: "alice"})
+			pipeline, err := handlers.ProcessPayload(k8sClient, requestCtx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			graph := &v1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.ID, Namespace: namespace}, graph)).To(Succeed())
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			parallelList, err := getParallelList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			objects := []metav1.Object{}
+			for i := range sequenceList.Items {
+				objects = append(objects, &sequenceList.Items[i])
+			}
+			for i := range parallelList.Items {
+				objects = append(objects, &parallelList.Items[i])
+			}
+			Expect(objects).To(HaveLen(4))
+			for _, obj := range objects {
+				Expect(obj.GetLabels()).To(HaveKeyWithValue(handlers.PipelineIdLabel, pipeline.ID))
+				Expect(obj.GetLabels()).To(HaveKeyWithValue(handlers.ManagedByLabel, handlers.ManagedBy))
+				Expect(obj.GetAnnotations()).To(HaveKeyWithValue(handlers.RequestIdAnnotation, "request-1"))
+				Expect(obj.GetAnnotations()).To(HaveKeyWithValue(handlers.CreatedByAnnotation, "alice"))
+				Expect(obj.GetOwnerReferences()).To(HaveLen(1))
+				Expect(obj.GetOwnerReferences()[0].UID).To(BeEquivalentTo(graph.UID))
+			}
+
+			By("keeping the original provenance when redeployed by someone else")
+			requestCtx = handlers.WithRequestInfo(ctx, handlers.RequestInfo{ID: "request-2", User: "bob"})
+			pipelinePayload.Nodes[1].Data.FaasID = "func-3"
+			_, err = handlers.UpdatePipeline(requestCtx, k8sClient, pipelinePayload, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+
+			sequence := &flows.Sequence{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Nodes[1].SequenceId, Namespace: namespace}, sequence)).To(Succeed())
+			Expect(sequence.Annotations).To(HaveKeyWithValue(handlers.RequestIdAnnotation, "request-1"))
+			Expect(sequence.Annotations).To(HaveKeyWithValue(handlers.CreatedByAnnotation, "alice"))
+		})
+
+		It("should not create anything when a function is missing", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-999", FaasID: "func-999"}}, //invalid node
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).To(HaveOccurred())
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(0))
+		})
+	})
+
+	Context("When previewing a pipeline", func() {
+		It("should return the manifests without creating them", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			preview, err := handlers.PreviewPipeline(ctx, k8sClient, pipelinePayload, namespace, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Sequences).To(HaveLen(3))
+			Expect(preview.Parallels).To(HaveLen(1))
+			Expect(preview.DryRunErrors).To(BeEmpty())
+
+			manifests, err := handlers.RenderManifests(&preview.Pipeline)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(manifests, "\nkind: Sequence\n")).To(BeEquivalentTo(3))
+			Expect(strings.Count(manifests, "\nkind: Parallel\n")).To(BeEquivalentTo(1))
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(0))
+
+			parallelList, err := getParallelList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parallelList.Items).To(HaveLen(0))
+		})
+
+		It("should render the parallels of a graph in the same order every time", func() {
+			/*
+				0 -> 1 -> 3
+				|    |
+				V    V
+				2    4 -> 5
+				     |
+				     V
+				     6
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+					{ID: "4", Data: model.NodeData{Label: "func-4", FaasID: "func-4"}},
+					{ID: "5", Data: model.NodeData{Label: "func-5", FaasID: "func-5"}},
+					{ID: "6", Data: model.NodeData{Label: "func-6", FaasID: "func-6"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+					{ID: "1-3", Source: "1", Target: "3"},
+					{ID: "1-4", Source: "1", Target: "4"},
+					{ID: "4-5", Source: "4", Target: "5"},
+					{ID: "4-6", Source: "4", Target: "6"},
+				},
+			}
+
+			preview, err := handlers.PreviewPipeline(ctx, k8sClient, pipelinePayload, namespace, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Parallels).To(HaveLen(3))
+			expected, err := handlers.RenderManifests(&preview.Pipeline)
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 10; i++ {
+				preview, err := handlers.PreviewPipeline(ctx, k8sClient, pipelinePayload, namespace, false)
+				Expect(err).NotTo(HaveOccurred())
+				manifests, err := handlers.RenderManifests(&preview.Pipeline)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifests).To(Equal(expected))
+			}
+		})
+
+		It("should dry run the update of a pipeline that is already deployed", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "previewed",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			before, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			versions := map[string]string{}
+			for _, sequence := range before.Items {
+				versions[sequence.Name] = sequence.ResourceVersion
+			}
+
+			preview, err := handlers.PreviewPipeline(ctx, k8sClient, pipelinePayload, namespace, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.ID).To(BeEquivalentTo(deployed.ID))
+			Expect(preview.DryRunErrors).To(BeEmpty())
+
+			// the dry run leaves the deployed resources as they were
+			after, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after.Items).To(HaveLen(len(before.Items)))
+			for _, sequence := range after.Items {
+				Expect(sequence.ResourceVersion).To(BeEquivalentTo(versions[sequence.Name]))
+			}
+
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, deployed.ID)).To(Succeed())
+		})
+	})
+
+	Context("When naming pipeline resources", func() {
+		It("should reach the same objects when the same graph is posted again", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			first, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first.Changes.Created).To(HaveLen(4))
+
+			second, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second.ID).To(BeEquivalentTo(first.ID))
+			Expect(second.Changes.Created).To(BeEmpty())
+			Expect(second.Changes.Updated).To(BeEmpty())
+			Expect(second.Changes.Deleted).To(BeEmpty())
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(3))
+		})
+
+		It("should derive valid names from the pipeline name", func() {
+			Expect(helpers.PipelineID("My Orders_Pipeline", nil, nil)).To(BeEquivalentTo("my-orders-pipeline"))
+
+			longName := strings.Repeat("orders", 20)
+			pipelineId := helpers.PipelineID(longName, nil, nil)
+			Expect(len(pipelineId)).To(BeNumerically("<=", 63))
+			Expect(pipelineId).NotTo(BeEquivalentTo(helpers.PipelineID(longName+"2", nil, nil)))
+
+			sequenceName := helpers.ResourceName(pipelineId, "sequence", "0", "1", "2")
+			Expect(len(sequenceName)).To(BeNumerically("<=", 63))
+			Expect(sequenceName).To(BeEquivalentTo(helpers.ResourceName(pipelineId, "sequence", "0", "1", "2")))
+			Expect(sequenceName).NotTo(BeEquivalentTo(helpers.ResourceName(pipelineId, "sequence", "0", "1")))
+		})
+	})
+
+	Context("When starting a pipeline from a source", func() {
+		It("should create the source with its sink on the first sequence", func() {
+			/*
+				ping -> 0 -> 1
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "ping", Type: model.PingSourceNode, Data: model.NodeData{Label: "Ping", PingSource: &model.PingSourceConfig{Schedule: "*/1 * * * *", Data: `{"hello":"world"}`}}},
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{
+					{ID: "ping-0", Source: "ping", Target: "0"},
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Entry.Name).To(BeEquivalentTo(pipeline.Nodes[1].SequenceId))
+
+			// the source is not a step of the sequence
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(1))
+			Expect(sequenceList.Items[0].Spec.Steps).To(HaveLen(2))
+
+			sources, err := getSourceList(ctx, "PingSource")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources.Items).To(HaveLen(1))
+			sinkName, _, _ := unstructured.NestedString(sources.Items[0].Object, "spec", "sink", "ref", "name")
+			Expect(sinkName).To(BeEquivalentTo(pipeline.Entry.Name))
+			schedule, _, _ := unstructured.NestedString(sources.Items[0].Object, "spec", "schedule")
+			Expect(schedule).To(BeEquivalentTo("*/1 * * * *"))
+
+			By("reading the source back with the pipeline")
+			deployed, err := handlers.GetPipeline(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployed.Sources).To(HaveLen(1))
+
+			By("removing the source with the pipeline")
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, pipeline.ID)).To(Succeed())
+			sources, err = getSourceList(ctx, "PingSource")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources.Items).To(HaveLen(0))
+		})
+
+		It("should give the node a source points at its own sequence", func() {
+			/*
+				api -> 1
+				0 ---> 1
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "api", Type: model.ApiServerSourceNode, Data: model.NodeData{Label: "API Server Source", ApiServerSource: &model.ApiServerSourceConfig{
+						Resources: []model.SourceResource{{APIVersion: "v1", Kind: "Event"}},
+					}}},
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{
+					{ID: "api-1", Source: "api", Target: "1"},
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Sequences).To(HaveLen(2))
+			Expect(pipeline.Sources).To(HaveLen(1))
+
+			sinkName, _, _ := unstructured.NestedString(pipeline.Sources[0].Object, "spec", "sink", "ref", "name")
+			Expect(sinkName).To(BeEquivalentTo(pipeline.Nodes[2].SequenceId))
+			Expect(pipeline.Entry.Name).To(BeEquivalentTo(pipeline.Nodes[1].SequenceId))
+		})
+	})
+
+	Context("When delivering the output of a pipeline", func() {
+		It("should reply to the sink at the end of a sequence", func() {
+			/*
+				0 -> 1 -> broker
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "broker", Type: model.BrokerNode, Data: model.NodeData{Label: "Broker", Sink: &model.SinkConfig{Name: "default"}}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-broker", Source: "1", Target: "broker"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the broker is not a step of the sequence
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(HaveLen(1))
+			Expect(sequenceList.Items[0].Spec.Steps).To(HaveLen(2))
+			Expect(sequenceList.Items[0].Spec.Reply).To(BeEquivalentTo(&duck.Destination{
+				Ref: &duck.KReference{APIVersion: "eventing.knative.dev/v1", Kind: "Broker", Name: "default"},
+			}))
+			Expect(handlers.PipelineResourceNames(pipeline).Nodes).NotTo(HaveKey("broker"))
+		})
+
+		It("should subscribe a sink to a parallel branch", func() {
+			/*
+				0 -> 1
+				|
+				V
+				web
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "web", Type: model.URINode, Data: model.NodeData{Label: "Web", Sink: &model.SinkConfig{URI: "https://example.com/events"}}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-web", Source: "0", Target: "web"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Sequences).To(HaveLen(2))
+			Expect(pipeline.Parallels).To(HaveLen(1))
+
+			branches := pipeline.Parallels[0].Spec.Branches
+			Expect(branches).To(HaveLen(2))
+			Expect(branches[0].Subscriber.Ref.Name).To(BeEquivalentTo(pipeline.Nodes[1].SequenceId))
+			Expect(branches[1].Subscriber.Ref).To(BeNil())
+			Expect(branches[1].Subscriber.URI.String()).To(BeEquivalentTo("https://example.com/events"))
+		})
+	})
+
+	Context("When configuring delivery", func() {
+		It("should set the delivery of every step and branch, letting nodes override the pipeline", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
+			*/
+			retry, nodeRetry := int32(3), int32(5)
+			pipelinePayload := model.PipelinePayload{
+				Delivery: &model.DeliveryConfig{
+					Retry:         &retry,
+					BackoffPolicy: "exponential",
+					BackoffDelay:  "PT0.5S",
+					DeadLetterSink: &model.DeadLetterConfig{
+						Type:       model.ServiceNode,
+						SinkConfig: model.SinkConfig{Name: "dead-letters"},
+					},
+				},
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1", Delivery: &model.DeliveryConfig{Retry: &nodeRetry}}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			stepDelivery := func(sequenceName string) *eventingduck.DeliverySpec {
+				sequence := &flows.Sequence{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: sequenceName, Namespace: namespace}, sequence)).To(Succeed())
+				Expect(sequence.Spec.Steps).To(HaveLen(1))
+				return sequence.Spec.Steps[0].Delivery
+			}
+
+			delivery := stepDelivery(pipeline.Nodes[0].SequenceId)
+			Expect(*delivery.Retry).To(BeEquivalentTo(3))
+			Expect(*delivery.BackoffPolicy).To(BeEquivalentTo(eventingduck.BackoffPolicyExponential))
+			Expect(*delivery.BackoffDelay).To(BeEquivalentTo("PT0.5S"))
+			Expect(delivery.DeadLetterSink.Ref.Kind).To(BeEquivalentTo("Service"))
+			Expect(delivery.DeadLetterSink.Ref.Name).To(BeEquivalentTo("dead-letters"))
+
+			delivery = stepDelivery(pipeline.Nodes[1].SequenceId)
+			Expect(*delivery.Retry).To(BeEquivalentTo(5))
+			Expect(delivery.DeadLetterSink.Ref.Name).To(BeEquivalentTo("dead-letters"))
+
+			parallel := &flows.Parallel{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Parallels[0].Name, Namespace: namespace}, parallel)).To(Succeed())
+			for _, branch := range parallel.Spec.Branches {
+				Expect(*branch.Delivery.Retry).To(BeEquivalentTo(3))
+			}
+		})
+
+		It("should deploy with the server defaults but store the graph as submitted", func() {
+			retry, defaultRetry := int32(2), int32(7)
+			pipelinePayload := model.PipelinePayload{
+				Delivery: &model.DeliveryConfig{Retry: &retry},
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+				},
+				Edges: []model.Edge{},
+			}
+			defaultsCtx := handlers.WithDefaults(ctx, handlers.PipelineDefaults{
+				Delivery: &model.DeliveryConfig{Retry: &defaultRetry, BackoffDelay: "PT2S"},
+			})
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, defaultsCtx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			sequence := &flows.Sequence{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Nodes[0].SequenceId, Namespace: namespace}, sequence)).To(Succeed())
+			Expect(*sequence.Spec.Steps[0].Delivery.Retry).To(BeEquivalentTo(2))
+			Expect(*sequence.Spec.Steps[0].Delivery.BackoffDelay).To(BeEquivalentTo("PT2S"))
+
+			graph, err := handlers.GetPipelineGraph(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Delivery).To(Equal(&model.DeliveryConfig{Retry: &retry}))
+
+			// a new server default reaches the pipeline when it is deployed again
+			defaultsCtx = handlers.WithDefaults(ctx, handlers.PipelineDefaults{
+				Delivery: &model.DeliveryConfig{BackoffDelay: "PT5S"},
+			})
+			_, err = handlers.ProcessPayload(k8sClient, defaultsCtx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Nodes[0].SequenceId, Namespace: namespace}, sequence)).To(Succeed())
+			Expect(*sequence.Spec.Steps[0].Delivery.BackoffDelay).To(BeEquivalentTo("PT5S"))
+		})
+
+		It("should reject delivery settings knative would not accept", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0", Delivery: &model.DeliveryConfig{BackoffDelay: "soon"}}},
+				},
+				Edges: []model.Edge{},
+			}
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.InvalidDelivery))
+			Expect(invalidErr.Errors[0].NodeID).To(BeEquivalentTo("0"))
+		})
+	})
+
+	Context("When choosing the channel", func() {
+		It("should use the chosen channel for every sequence and parallel", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Channel: &model.ChannelConfig{
+					APIVersion: "messaging.knative.dev/v1",
+					Kind:       "Channel",
+					Spec:       map[string]interface{}{"delivery": map[string]interface{}{"retry": 2}},
+				},
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, s := range pipeline.Sequences {
+				sequence := &flows.Sequence{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: s.Name, Namespace: namespace}, sequence)).To(Succeed())
+				Expect(sequence.Spec.ChannelTemplate.Kind).To(BeEquivalentTo("Channel"))
+				Expect(sequence.Spec.ChannelTemplate.Spec.Raw).To(MatchJSON(`{"delivery":{"retry":2}}`))
+			}
+			parallel := &flows.Parallel{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Parallels[0].Name, Namespace: namespace}, parallel)).To(Succeed())
+			Expect(parallel.Spec.ChannelTemplate.Kind).To(BeEquivalentTo("Channel"))
+		})
+
+		It("should reject a channel kind that is not installed", func() {
+			pipelinePayload := model.PipelinePayload{
+				Channel: &model.ChannelConfig{
+					APIVersion: "messaging.knative.dev/v1beta1",
+					Kind:       "KafkaChannel",
+					Spec:       map[string]interface{}{"numPartitions": 3, "replicationFactor": 1},
+				},
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+				},
+				Edges: []model.Edge{},
+			}
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.UnknownChannel))
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(BeEmpty())
+		})
+	})
+
+	Context("When routing branches with conditions", func() {
+		It("should filter each branch by its condition", func() {
+			/*
+				0 -> 1   type=order.created
+				|
+				|--> 2   otherwise
+				|
+				V
+				3 -> 4   every event
+			*/
+			pipelinePayload := model.PipelinePayload{
+				FilterURI: "http://filter.knative.svc.cluster.local",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+					{ID: "4", Data: model.NodeData{Label: "func-4", FaasID: "func-4"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{Attributes: map[string]string{"type": "order.created"}}},
+					{ID: "0-2", Source: "0", Target: "2", Condition: &model.EdgeCondition{Otherwise: true}},
+					{ID: "0-3", Source: "0", Target: "3"},
+					{ID: "3-4", Source: "3", Target: "4"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			parallel := &flows.Parallel{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Parallels[0].Name, Namespace: namespace}, parallel)).To(Succeed())
+			filters := map[string]*duck.Destination{}
+			for _, branch := range parallel.Spec.Branches {
+				filters[branch.Subscriber.Ref.Name] = branch.Filter
+			}
+			Expect(filters).To(HaveLen(3))
+
+			filter := filters[pipeline.Nodes[1].SequenceId]
+			Expect(filter.URI.Host).To(BeEquivalentTo("filter.knative.svc.cluster.local"))
+			Expect(filter.URI.URL().Query()).To(BeEquivalentTo(map[string][]string{"type": {"order.created"}}))
+
+			filter = filters[pipeline.Nodes[2].SequenceId]
+			Expect(filter.URI.URL().Query()).To(BeEquivalentTo(map[string][]string{handlers.ExcludeParam: {"type=order.created"}}))
+
+			Expect(filters[pipeline.Nodes[3].SequenceId]).To(BeNil())
+		})
+
+		It("should evaluate the conditions with the filter service", func() {
+			filterServer := httptest.NewServer(handlers.FilterHandler{})
+			DeferCleanup(filterServer.Close)
+
+			send := func(query string, header map[string]string, body string) (int, string) {
+				request, err := http.NewRequest(http.MethodPost, filterServer.URL+"?"+query, strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				for name, value := range header {
+					request.Header.Set(name, value)
+				}
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				defer response.Body.Close()
+				reply, err := io.ReadAll(response.Body)
+				Expect(err).NotTo(HaveOccurred())
+				return response.StatusCode, string(reply)
+			}
+			created := map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "1", "Ce-Source": "shop", "Ce-Type": "order.created", "Ce-Region": "eu"}
+			shipped := map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "2", "Ce-Source": "shop", "Ce-Type": "order.shipped"}
+
+			// an event that matches is replied with as it came in
+			code, reply := send("type=order.created&region=eu", created, `{"id":1}`)
+			Expect(code).To(BeEquivalentTo(http.StatusOK))
+			Expect(reply).To(BeEquivalentTo(`{"id":1}`))
+
+			code, reply = send("type=order.created", shipped, `{"id":2}`)
+			Expect(code).To(BeEquivalentTo(http.StatusAccepted))
+			Expect(reply).To(BeEmpty())
+
+			// otherwise branches take what the other branches do not
+			code, _ = send(handlers.ExcludeParam+"=type%3Dorder.created", created, `{"id":1}`)
+			Expect(code).To(BeEquivalentTo(http.StatusAccepted))
+			code, _ = send(handlers.ExcludeParam+"=type%3Dorder.created", shipped, `{"id":2}`)
+			Expect(code).To(BeEquivalentTo(http.StatusOK))
+
+			structured := map[string]string{"Content-Type": "application/cloudevents+json"}
+			code, reply = send("type=order.created", structured, `{"specversion":"1.0","id":"3","source":"shop","type":"order.created"}`)
+			Expect(code).To(BeEquivalentTo(http.StatusOK))
+			Expect(reply).To(ContainSubstring(`"id":"3"`))
+
+			code, _ = send("type=order.created", map[string]string{}, `{"id":4}`)
+			Expect(code).To(BeEquivalentTo(http.StatusBadRequest))
+		})
+
+		It("should filter a branch through a function", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{FaasID: "func-5"}},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			parallel := &flows.Parallel{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Parallels[0].Name, Namespace: namespace}, parallel)).To(Succeed())
+			for _, branch := range parallel.Spec.Branches {
+				if branch.Subscriber.Ref.Name == pipeline.Nodes[1].SequenceId {
+					Expect(branch.Filter.Ref.Kind).To(BeEquivalentTo("Service"))
+					Expect(branch.Filter.Ref.Name).To(BeEquivalentTo("func-5"))
+				} else {
+					Expect(branch.Filter).To(BeNil())
+				}
+			}
+		})
+
+		It("should reject conditions that can not become a branch filter", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{Attributes: map[string]string{"type": "order.created"}}},
+					{ID: "0-2", Source: "0", Target: "2"},
+					{ID: "2-3", Source: "2", Target: "3", Condition: &model.EdgeCondition{FaasID: "func-5"}},
+				},
+			}
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(2))
+			edgeIds := []string{}
+			for _, validationErr := range invalidErr.Errors {
+				Expect(validationErr.Code).To(BeEquivalentTo(helpers.InvalidCondition))
+				edgeIds = append(edgeIds, validationErr.EdgeID)
+			}
+			// 2-3 is not on a fork, 0-1 has no filter service to evaluate it
+			Expect(edgeIds).To(ConsistOf("2-3", "0-1"))
+		})
+	})
+
+	Context("When using the broker backend", func() {
+		It("should route every edge through one broker with a trigger per edge", func() {
+			/*
+				ping -> 0 -> 1 -> events   type=order.created
+				        |
+				        V
+				        2                  otherwise
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Backend: model.BrokerBackend,
+				StepURI: "http://step.knative.svc.cluster.local",
+				Nodes: []model.Node{
+					{ID: "ping", Type: model.PingSourceNode, Data: model.NodeData{Label: "Ping", PingSource: &model.PingSourceConfig{Schedule: "*/1 * * * *"}}},
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "events", Type: model.URINode, Data: model.NodeData{Label: "Events", Sink: &model.SinkConfig{URI: "https://example.com/events"}}},
+				},
+				Edges: []model.Edge{
+					{ID: "ping-0", Source: "ping", Target: "0"},
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{Attributes: map[string]string{"type": "order.created"}}},
+					{ID: "0-2", Source: "0", Target: "2", Condition: &model.EdgeCondition{Otherwise: true}},
+					{ID: "1-events", Source: "1", Target: "events"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Entry.Kind).To(BeEquivalentTo("Broker"))
+
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequenceList.Items).To(BeEmpty())
+
+			brokerList := &eventing.BrokerList{}
+			Expect(k8sClient.List(ctx, brokerList, client.InNamespace(namespace))).To(Succeed())
+			Expect(brokerList.Items).To(HaveLen(1))
+			Expect(brokerList.Items[0].Name).To(BeEquivalentTo(pipeline.Entry.Name))
+			Expect(brokerList.Items[0].Labels[handlers.PipelineIdLabel]).To(BeEquivalentTo(pipeline.ID))
+
+			triggerList := &eventing.TriggerList{}
+			Expect(k8sClient.List(ctx, triggerList, client.InNamespace(namespace))).To(Succeed())
+			triggers := map[string]eventing.Trigger{}
+			for _, trigger := range triggerList.Items {
+				Expect(trigger.Spec.Broker).To(BeEquivalentTo(pipeline.Entry.Name))
+				triggers[trigger.Annotations[handlers.NodesAnnotation]] = trigger
+			}
+			// one per edge and one for the events sent to the pipeline
+			Expect(triggers).To(HaveLen(5))
+
+			entry := triggers["0"]
+			Expect(entry.Spec.Filter.Attributes).To(BeEquivalentTo(map[string]string{handlers.StepExtension: handlers.EntryStep}))
+			// functions are called through the step service, which sets the step on their reply
+			Expect(entry.Spec.Subscriber.Ref).To(BeNil())
+			Expect(entry.Spec.Subscriber.URI.Host).To(BeEquivalentTo("step.knative.svc.cluster.local"))
+			Expect(entry.Spec.Subscriber.URI.URL().Query()).To(BeEquivalentTo(map[string][]string{
+				handlers.StepExtension: {"0"},
+				handlers.ServiceParam:  {"func-0"},
+			}))
+
+			Expect(triggers["ping,0"].Spec.Filter.Attributes).To(BeEquivalentTo(map[string]string{handlers.StepExtension: "ping"}))
+			Expect(triggers["0,1"].Spec.Filter.Attributes).To(BeEquivalentTo(map[string]string{handlers.StepExtension: "0", "type": "order.created"}))
+			Expect(triggers["0,2"].Spec.Filters).To(BeEquivalentTo([]eventing.SubscriptionsAPIFilter{
+				{Exact: map[string]string{handlers.StepExtension: "0"}},
+				{Not: &eventing.SubscriptionsAPIFilter{Any: []eventing.SubscriptionsAPIFilter{{Exact: map[string]string{"type": "order.created"}}}}},
+			}))
+			Expect(triggers["1,events"].Spec.Subscriber.URI.String()).To(BeEquivalentTo("https://example.com/events"))
+
+			sources, err := getSourceList(ctx, "PingSource")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources.Items).To(HaveLen(1))
+			sink, _, _ := unstructured.NestedString(sources.Items[0].Object, "spec", "sink", "ref", "kind")
+			Expect(sink).To(BeEquivalentTo("Broker"))
+			step, _, _ := unstructured.NestedString(sources.Items[0].Object, "spec", "ceOverrides", "extensions", handlers.StepExtension)
+			Expect(step).To(BeEquivalentTo("ping"))
+
+			resources := handlers.PipelineResourceNames(pipeline)
+			Expect(resources.Brokers).To(ConsistOf(pipeline.Entry.Name))
+			Expect(resources.Triggers).To(HaveLen(5))
+			Expect(resources.Nodes["1"]).To(BeEquivalentTo(pipeline.Entry.Name))
+
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, pipeline.ID)).To(Succeed())
+			Expect(k8sClient.List(ctx, triggerList, client.InNamespace(namespace))).To(Succeed())
+			Expect(triggerList.Items).To(BeEmpty())
+			Expect(k8sClient.List(ctx, brokerList, client.InNamespace(namespace))).To(Succeed())
+			Expect(brokerList.Items).To(BeEmpty())
+		})
+
+		It("should replace the sequences when a pipeline switches to the broker backend", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Sequences).To(HaveLen(1))
+
+			pipelinePayload.Backend = model.BrokerBackend
+			pipelinePayload.StepURI = "http://step.knative.svc.cluster.local"
+			updated, err := handlers.UpdatePipeline(ctx, k8sClient, pipelinePayload, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Changes.Deleted).To(ConsistOf(pipeline.Sequences[0].Name))
+			Expect(updated.Changes.Created).To(HaveLen(3))
+
+			fetched, err := handlers.GetPipeline(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fetched.Backend).To(BeEquivalentTo(model.BrokerBackend))
+			Expect(fetched.Sequences).To(BeEmpty())
+			Expect(fetched.Brokers).To(HaveLen(1))
+			Expect(fetched.Triggers).To(HaveLen(2))
+			Expect(fetched.Entry.Name).To(BeEquivalentTo(fetched.Brokers[0].Name))
+		})
+
+		It("should reject what the broker backend can not express", func() {
+			pipelinePayload := model.PipelinePayload{
+				Backend: model.BrokerBackend,
+				StepURI: "http://step.knative.svc.cluster.local",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{FaasID: "func-5"}},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			_, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.InvalidCondition))
+			Expect(invalidErr.Errors[0].EdgeID).To(BeEquivalentTo("0-1"))
+
+			// functions can only be called through the step service
+			pipelinePayload.Edges[0].Condition = nil
+			pipelinePayload.StepURI = ""
+			_, err = handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.InvalidBackend))
+
+			// events sent to the pipeline come with the entry step, a node by that id would get them as well
+			pipelinePayload.StepURI = "http://step.knative.svc.cluster.local"
+			pipelinePayload.Nodes[2].ID, pipelinePayload.Edges[1].Target = handlers.EntryStep, handlers.EntryStep
+			_, err = handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.ReservedNodeID))
+			Expect(invalidErr.Errors[0].NodeID).To(BeEquivalentTo(handlers.EntryStep))
+
+			pipelinePayload.Backend = "workflow"
+			pipelinePayload.Edges[0].Condition = nil
+			_, err = handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.InvalidBackend))
+		})
+
+		It("should set the step on every reply it relays", func() {
+			// the functions run locally, so they are dialed in place of the address of their ksvc
+			functions := map[string]*httptest.Server{}
+			dialer := &net.Dialer{}
+			stepServer := httptest.NewServer(handlers.StepHandler{
+				Namespace: namespace,
+				HTTPClient: &http.Client{Transport: &http.Transport{
+					DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+						Expect(address).To(HaveSuffix("." + namespace + ".svc.cluster.local:80"))
+						function, exists := functions[strings.Split(address, ".")[0]]
+						if !exists {
+							return nil, fmt.Errorf("no function at %s", address)
+						}
+						return dialer.DialContext(ctx, network, function.Listener.Addr().String())
+					},
+				}},
+			})
+			DeferCleanup(stepServer.Close)
+
+			send := func(service string, header map[string]string, body string) *http.Response {
+				query := url.Values{handlers.StepExtension: {"1"}, handlers.ServiceParam: {service}}
+				request, err := http.NewRequest(http.MethodPost, stepServer.URL+"?"+query.Encode(), strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				for name, value := range header {
+					request.Header.Set(name, value)
+				}
+				response, err := http.DefaultClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				DeferCleanup(response.Body.Close)
+				return response
+			}
+			relay := func(function *httptest.Server, header map[string]string, body string) *http.Response {
+				name := fmt.Sprintf("func-%d", len(functions))
+				functions[name] = function
+				return send(name, header, body)
+			}
+			event := map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "1", "Ce-Source": "shop", "Ce-Type": "order.created", "Ce-Mochastep": "0"}
+
+			// a function echoing its input would match its own trigger again without the step service
+			echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range r.Header {
+					w.Header()[name] = values
+				}
+				body, _ := io.ReadAll(r.Body)
+				_, _ = w.Write(body)
+			}))
+			DeferCleanup(echo.Close)
+			response := relay(echo, event, `{"id":1}`)
+			Expect(response.StatusCode).To(BeEquivalentTo(http.StatusOK))
+			Expect(response.Header.Get("Ce-" + handlers.StepExtension)).To(BeEquivalentTo("1"))
+			Expect(response.Header.Get("Ce-Type")).To(BeEquivalentTo("order.created"))
+
+			// a plain reply goes on as an event with the attributes of the one it answers
+			plain := functionServer("plain", "")
+			response = relay(plain, event, `{"id":1}`)
+			Expect(response.StatusCode).To(BeEquivalentTo(http.StatusOK))
+			Expect(response.Header.Get("Ce-Id")).NotTo(BeEquivalentTo("1"))
+			Expect(response.Header.Get("Ce-Source")).To(BeEquivalentTo("shop"))
+			Expect(response.Header.Get("Ce-" + handlers.StepExtension)).To(BeEquivalentTo("1"))
+
+			empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			DeferCleanup(empty.Close)
+			Expect(relay(empty, event, `{"id":1}`).StatusCode).To(BeEquivalentTo(http.StatusAccepted))
+
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			DeferCleanup(failing.Close)
+			Expect(relay(failing, event, `{"id":1}`).StatusCode).To(BeEquivalentTo(http.StatusServiceUnavailable))
+
+			Expect(relay(echo, map[string]string{}, `{"id":1}`).StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+
+			By("keeping the credentials of the trigger from the function")
+			var received http.Header
+			recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header.Clone()
+			}))
+			DeferCleanup(recorder.Close)
+			withCredentials := map[string]string{"Authorization": "Bearer secret", "Cookie": "session=secret"}
+			for name, value := range event {
+				withCredentials[name] = value
+			}
+			Expect(relay(recorder, withCredentials, `{"id":1}`).StatusCode).To(BeEquivalentTo(http.StatusAccepted))
+			Expect(received.Get("Ce-Id")).To(BeEquivalentTo("1"))
+			Expect(received).NotTo(HaveKey("Authorization"))
+			Expect(received).NotTo(HaveKey("Cookie"))
+
+			By("only calling ksvcs in its namespace")
+			for _, service := range []string{"", "http://example.com", "func-0.other.svc.cluster.local", "example.com/path"} {
+				Expect(send(service, event, `{"id":1}`).StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			}
+		})
+	})
+
+	Context("When exporting a workflow", func() {
+		It("should export a task per function with the dependencies of its edges", func() {
+			/*
+				0 -> 1 -> 2 -> 4 -> 5
+				     |         ^
+				     V         |
+				     3 --------|
+
+				0 is a ping source and 5 a uri sink, neither becomes a task
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Type: model.PingSourceNode, Data: model.NodeData{Label: "ping", PingSource: &model.PingSourceConfig{Schedule: "*/5 * * * *"}}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+					{ID: "4", Data: model.NodeData{Label: "func-4", FaasID: "func-4"}},
+					{ID: "5", Type: model.URINode, Data: model.NodeData{Label: "out", Sink: &model.SinkConfig{URI: "https://example.com/out"}}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-2", Source: "1", Target: "2"},
+					{ID: "1-3", Source: "1", Target: "3"},
+					{ID: "2-4", Source: "2", Target: "4"},
+					{ID: "3-4", Source: "3", Target: "4"},
+					{ID: "4-5", Source: "4", Target: "5"},
+				},
+			}
+
+			// the address only shows up once knative has reconciled the ksvc
+			_, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).To(HaveOccurred())
+
+			for _, faasId := range []string{"func-1", "func-2", "func-3", "func-4"} {
+				Expect(setKsvcAddress(ctx, faasId)).To(Succeed())
+			}
+			export, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(export.Mode).To(BeEquivalentTo(handlers.WorkflowHTTPMode))
+			Expect(export.Workflow.GetKind()).To(BeEquivalentTo("WorkflowTemplate"))
+			Expect(export.Workflow.GetName()).To(BeEquivalentTo(export.ID))
+
+			tasks := workflowTasks(export.Workflow)
+			Expect(tasks).To(HaveLen(4))
+			Expect(tasks).To(HaveKey("node-1"))
+			Expect(tasks["node-1"]).NotTo(HaveKey("dependencies"))
+			Expect(tasks["node-2"]["dependencies"]).To(ConsistOf("node-1"))
+			Expect(tasks["node-3"]["dependencies"]).To(ConsistOf("node-1"))
+			Expect(tasks["node-4"]["dependencies"]).To(ConsistOf("node-2", "node-3"))
+
+			// joins get the results of every task before them
+			data, _, _ := unstructured.NestedSlice(tasks["node-4"], "arguments", "parameters")
+			Expect(data[0]).To(HaveKeyWithValue("value",
+				"[{{tasks.node-2.outputs.result}},{{tasks.node-3.outputs.result}}]"))
+
+			templates := workflowTemplates(export.Workflow)
+			url, _, _ := unstructured.NestedString(templates["node-2"], "http", "url")
+			Expect(url).To(BeEquivalentTo("http://func-2.knative.svc.cluster.local"))
+		})
+
+		It("should run the images of the functions in container mode", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{},
+			}
+
+			ksvc, err := getKsvc(ctx, "func-1")
+			Expect(err).NotTo(HaveOccurred())
+			ksvc.Spec.Template.Spec.Containers = []v1.Container{{Image: "registry.example.com/func-1:latest"}}
+			Expect(k8sClient.Update(ctx, ksvc)).To(Succeed())
+
+			export, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, handlers.WorkflowContainerMode)
+			Expect(err).NotTo(HaveOccurred())
+
+			templates := workflowTemplates(export.Workflow)
+			image, _, _ := unstructured.NestedString(templates["node-1"], "container", "image")
+			Expect(image).To(BeEquivalentTo("registry.example.com/func-1:latest"))
+			env, _, _ := unstructured.NestedSlice(templates["node-1"], "container", "env")
+			Expect(env).To(ContainElement(HaveKeyWithValue("name", handlers.DataEnv)))
+
+			_, err = handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "cron")
+			Expect(errors.Is(err, handlers.ErrUnknownWorkflowMode)).To(BeTrue())
+		})
+
+		It("should apply the workflow when argo is installed", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "batch",
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+			Expect(setKsvcAddress(ctx, "func-1")).To(Succeed())
+			Expect(setKsvcAddress(ctx, "func-2")).To(Succeed())
+
+			export, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handlers.ApplyWorkflow(ctx, k8sClient, &export.Workflow)).To(Succeed())
+
+			applied := &unstructured.Unstructured{}
+			applied.SetGroupVersionKind(export.Workflow.GroupVersionKind())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "batch", Namespace: namespace}, applied)).To(Succeed())
+			Expect(workflowTasks(*applied)).To(HaveLen(2))
+
+			// applying again updates the template in place
+			pipelinePayload.Edges = []model.Edge{}
+			export, err = handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handlers.ApplyWorkflow(ctx, k8sClient, &export.Workflow)).To(Succeed())
+
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "batch", Namespace: namespace}, applied)).To(Succeed())
+			Expect(workflowTasks(*applied)["node-2"]).NotTo(HaveKey("dependencies"))
+		})
+
+		It("should go along with the pipeline it was exported from", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "batch-deployed",
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+			Expect(setKsvcAddress(ctx, "func-1")).To(Succeed())
+			Expect(setKsvcAddress(ctx, "func-2")).To(Succeed())
+
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			export, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(export.ID).To(BeEquivalentTo(deployed.ID))
+			Expect(handlers.ApplyWorkflow(ctx, k8sClient, &export.Workflow)).To(Succeed())
+
+			applied := &unstructured.Unstructured{}
+			applied.SetGroupVersionKind(export.Workflow.GroupVersionKind())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: deployed.ID, Namespace: namespace}, applied)).To(Succeed())
+			Expect(applied.GetOwnerReferences()).To(HaveLen(1))
+			Expect(applied.GetOwnerReferences()[0].Kind).To(BeEquivalentTo("ConfigMap"))
+			Expect(applied.GetOwnerReferences()[0].Name).To(BeEquivalentTo(deployed.ID))
+
+			pipeline, err := handlers.GetPipeline(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Workflows).To(ConsistOf(deployed.ID))
+
+			// redeploying leaves the workflow alone
+			redeployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(redeployed.Changes.Deleted).To(BeEmpty())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: deployed.ID, Namespace: namespace}, applied)).To(Succeed())
+
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, deployed.ID)).To(Succeed())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: deployed.ID, Namespace: namespace}, applied)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should delete a workflow applied without deploying the pipeline", func() {
+			pipelinePayload := model.PipelinePayload{
+				Name: "batch-only",
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{},
+			}
+			Expect(setKsvcAddress(ctx, "func-1")).To(Succeed())
+
+			export, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handlers.ApplyWorkflow(ctx, k8sClient, &export.Workflow)).To(Succeed())
+			Expect(export.Workflow.GetOwnerReferences()).To(BeEmpty())
+
+			pipeline, err := handlers.GetPipeline(ctx, k8sClient, namespace, export.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Workflows).To(ConsistOf(export.ID))
+
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, export.ID)).To(Succeed())
+			applied := &unstructured.Unstructured{}
+			applied.SetGroupVersionKind(export.Workflow.GroupVersionKind())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: export.ID, Namespace: namespace}, applied)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should reject edge conditions", func() {
+			pipelinePayload := model.PipelinePayload{
+				FilterURI: "http://filter.knative.svc.cluster.local",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1", Condition: &model.EdgeCondition{Attributes: map[string]string{"type": "order.created"}}},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			_, err := handlers.ExportWorkflow(ctx, k8sClient, pipelinePayload, namespace, "")
+			var invalidErr *handlers.InvalidGraphError
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Errors).To(HaveLen(1))
+			Expect(invalidErr.Errors[0].Code).To(BeEquivalentTo(helpers.InvalidCondition))
+			Expect(invalidErr.Errors[0].EdgeID).To(BeEquivalentTo("0-1"))
+		})
+	})
+
+	Context("When running a graph locally", func() {
+		It("should follow the chains, forks and joins of the graph", func() {
+			/*
+				1 -> 2 -> 4 -> 5
+				|         ^
+				V         |
+				3 --------|
+
+				4 is a join and runs once for every event reaching it
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+					{ID: "4", Data: model.NodeData{Label: "func-4", FaasID: "func-4"}},
+					{ID: "5", Data: model.NodeData{Label: "func-5", FaasID: "func-5"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+					{ID: "1-3", Source: "1", Target: "3"},
+					{ID: "2-4", Source: "2", Target: "4"},
+					{ID: "3-4", Source: "3", Target: "4"},
+					{ID: "4-5", Source: "4", Target: "5"},
+				},
+			}
+			executor := &handlers.LocalExecutor{Endpoints: map[string]string{}}
+			for _, node := range pipelinePayload.Nodes {
+				executor.Endpoints[node.ID] = functionServer(node.ID, "").URL
+			}
+
+			execution, err := executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Data: "in"})
+			Expect(err).NotTo(HaveOccurred())
+
+			calls := []string{}
+			for _, step := range execution.Steps {
+				Expect(step.Error).To(BeEmpty())
+				calls = append(calls, step.NodeID)
+			}
+			Expect(calls).To(Equal([]string{"1", "2", "4", "5", "3", "4", "5"}))
+
+			Expect(execution.Outputs).To(HaveLen(1))
+			data := []string{}
+			for _, event := range execution.Outputs["5"] {
+				Expect(event.Type).To(BeEquivalentTo(handlers.LocalEventType))
+				data = append(data, event.Data)
+			}
+			Expect(data).To(ConsistOf("in>1>2>4>5", "in>1>3>4>5"))
+		})
+
+		It("should read replies in structured mode", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+			structured := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+				_, _ = w.Write([]byte(`{"specversion":"1.0","id":"reply-1","source":"shop","type":"order.created",` +
+					`"tenant":"acme","datacontenttype":"application/json","data":{"id":1}}`))
+			}))
+			DeferCleanup(structured.Close)
+			executor := &handlers.LocalExecutor{Endpoints: map[string]string{
+				"1": structured.URL,
+				"2": functionServer("2", "").URL,
+			}}
+
+			execution, err := executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Data: "in"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(execution.Steps).To(HaveLen(2))
+			Expect(execution.Steps[0].Output).To(Equal(&model.ExecutionEvent{
+				ID:          "reply-1",
+				Source:      "shop",
+				Type:        "order.created",
+				ContentType: "application/json",
+				Extensions:  map[string]string{"tenant": "acme"},
+				Data:        `{"id":1}`,
+			}))
+			Expect(execution.Steps[1].Input.Type).To(BeEquivalentTo("order.created"))
+			Expect(execution.Outputs["2"][0].Data).To(BeEquivalentTo(`{"id":1}>2`))
+		})
+
+		It("should only send events down the branches whose condition matches", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+					{ID: "4", Type: model.URINode, Data: model.NodeData{Label: "out", Sink: &model.SinkConfig{URI: "https://example.com/out"}}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2", Condition: &model.EdgeCondition{Attributes: map[string]string{"type": "order.created"}}},
+					{ID: "1-3", Source: "1", Target: "3", Condition: &model.EdgeCondition{Otherwise: true}},
+					{ID: "2-4", Source: "2", Target: "4"},
+				},
+			}
+			executor := &handlers.LocalExecutor{Endpoints: map[string]string{
+				"1": functionServer("1", "order.created").URL,
+				"2": functionServer("2", "").URL,
+				"3": functionServer("3", "").URL,
+			}}
+
+			execution, err := executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Type: "order.placed", Data: "in"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(execution.Steps).To(HaveLen(2))
+			Expect(execution.Steps[0].Input.Type).To(BeEquivalentTo("order.placed"))
+			Expect(execution.Steps[0].Output.Type).To(BeEquivalentTo("order.created"))
+			Expect(execution.Steps[1].NodeID).To(BeEquivalentTo("2"))
+
+			// the sink keeps what reached it
+			Expect(execution.Outputs).To(HaveKey("4"))
+			Expect(execution.Outputs["4"][0].Data).To(BeEquivalentTo("in>1>2"))
+		})
+
+		It("should call the ksvc address unless the node has an endpoint", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+			server := functionServer("1", "")
+			address, err := apis.ParseURL(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(setKsvcURL(ctx, "func-1", address)).To(Succeed())
+
+			// func-2 has neither an address nor an endpoint, nothing is called
+			executor := &handlers.LocalExecutor{Client: k8sClient, Namespace: namespace}
+			_, err = executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Data: "in"})
+			Expect(errors.Is(err, handlers.ErrNoEndpoint)).To(BeTrue())
+
+			executor.Endpoints = map[string]string{"2": functionServer("2", "").URL}
+			execution, err := executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Data: "in"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(execution.Steps).To(HaveLen(2))
+			Expect(execution.Steps[0].URL).To(BeEquivalentTo(server.URL))
+			Expect(execution.Outputs["2"][0].Data).To(BeEquivalentTo("in>1>2"))
+		})
+
+		It("should end the path of a node that fails", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "1-2", Source: "1", Target: "2"},
+				},
+			}
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			DeferCleanup(failing.Close)
+			executor := &handlers.LocalExecutor{Endpoints: map[string]string{
+				"1": failing.URL,
+				"2": functionServer("2", "").URL,
+			}}
+
+			execution, err := executor.Run(ctx, pipelinePayload, model.ExecutionEvent{Data: "in"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(execution.Steps).To(HaveLen(1))
+			Expect(execution.Steps[0].Status).To(Equal(http.StatusInternalServerError))
+			Expect(execution.Steps[0].Error).NotTo(BeEmpty())
+			Expect(execution.Steps[0].Output).To(BeNil())
+			Expect(execution.Outputs).To(BeEmpty())
+		})
+	})
+
+	Context("When picking a translator", func() {
+		It("should deploy whatever a registered translator produces", func() {
+			handlers.RegisterTranslator("single", singleSequenceTranslator{})
+
+			pipelinePayload := model.PipelinePayload{
+				Backend: "single",
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Changes.Created).To(ConsistOf(pipeline.ID+"-all", pipeline.ID+"-all"))
+			Expect(pipeline.Entry.Kind).To(BeEquivalentTo("Sequence"))
+
+			fetched, err := handlers.GetPipeline(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fetched.Backend).To(BeEquivalentTo("single"))
+			Expect(fetched.Sequences).To(HaveLen(1))
+			Expect(fetched.Sequences[0].Spec.Steps).To(HaveLen(3))
+			Expect(fetched.Parallels).To(BeEmpty())
+			Expect(handlers.PipelineResourceNames(fetched).Others).To(ConsistOf("ServiceAccount/" + pipeline.ID + "-all"))
+
+			By("owning and labelling the kinds only the translator knows")
+			serviceAccount := &v1.ServiceAccount{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.ID + "-all", Namespace: namespace}, serviceAccount)).To(Succeed())
+			Expect(serviceAccount.Labels).To(HaveKeyWithValue(handlers.PipelineIdLabel, pipeline.ID))
+			Expect(serviceAccount.OwnerReferences).To(HaveLen(1))
+
+			By("leaving them alone when nothing changed")
+			redeployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(redeployed.Changes.Created).To(BeEmpty())
+			Expect(redeployed.Changes.Updated).To(BeEmpty())
+
+			Expect(handlers.DeletePipeline(ctx, k8sClient, namespace, pipeline.ID)).To(Succeed())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.ID + "-all", Namespace: namespace}, serviceAccount)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should fall back to the flows translator and reject unknown backends", func() {
+			translator, err := handlers.TranslatorFor("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(translator).NotTo(BeNil())
+
+			_, err = handlers.TranslatorFor("workflow")
+			Expect(errors.Is(err, handlers.ErrUnknownBackend)).To(BeTrue())
+		})
+	})
+
+	Context("When reporting what was deployed", func() {
+		It("should list the resources and the sequence of every node", func() {
+			/*
+				0 -> 1 -> 2
+				|
+				V
+				3
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-2", Source: "1", Target: "2"},
+					{ID: "0-3", Source: "0", Target: "3"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			resources := handlers.PipelineResourceNames(pipeline)
+			Expect(resources.Sequences).To(HaveLen(3))
+			Expect(resources.Parallels).To(HaveLen(1))
+			Expect(resources.Nodes).To(HaveLen(4))
+			Expect(resources.Nodes["0"]).To(BeEquivalentTo(pipeline.Entry.Name))
+			Expect(resources.Nodes["1"]).To(BeEquivalentTo(pipeline.Nodes[1].SequenceId))
+			Expect(resources.Nodes["2"]).To(BeEquivalentTo(resources.Nodes["1"]))
+			Expect(resources.Nodes["3"]).To(BeEquivalentTo(pipeline.Nodes[3].SequenceId))
+		})
+	})
+
+	Context("When checking the status of a pipeline", func() {
+		It("should report what holds each node back until everything is ready", func() {
+			/*
+				0 -> 1
+				|
+				V
+				2
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "0-2", Source: "0", Target: "2"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			// nothing reconciles the resources in the test env
+			status, err := handlers.GetPipelineStatus(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Ready).To(BeFalse())
+			Expect(status.Resources).To(HaveLen(4))
+			Expect(status.Nodes).To(HaveLen(3))
+			for _, node := range pipeline.Nodes {
+				Expect(status.Nodes[node.ID].Ready).To(BeFalse())
+				Expect(status.Nodes[node.ID].Reasons).NotTo(BeEmpty())
+				Expect(status.Nodes[node.ID].Service).To(BeEquivalentTo(node.Data.FaasID))
+			}
+			Expect(status.Nodes["1"].Sequence).To(BeEquivalentTo(pipeline.Nodes[1].SequenceId))
+
+			By("marking every resource ready")
+			sequenceList, err := getSequenceList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range sequenceList.Items {
+				Expect(markReady(ctx, &sequenceList.Items[i], &sequenceList.Items[i].Status.Status)).To(Succeed())
+			}
+			parallelList, err := getParallelList(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range parallelList.Items {
+				Expect(markReady(ctx, &parallelList.Items[i], &parallelList.Items[i].Status.Status)).To(Succeed())
+			}
+			for _, node := range pipeline.Nodes {
+				ksvc := &serving.Service{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: node.Data.FaasID, Namespace: namespace}, ksvc)).To(Succeed())
+				Expect(markReady(ctx, ksvc, &ksvc.Status.Status)).To(Succeed())
+			}
+
+			status, err = handlers.GetPipelineStatus(ctx, k8sClient, namespace, pipeline.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Ready).To(BeTrue())
+			for _, node := range pipeline.Nodes {
+				Expect(status.Nodes[node.ID].Ready).To(BeTrue())
+				Expect(status.Nodes[node.ID].Reasons).To(BeEmpty())
+			}
+		})
+
+		It("should give up waiting with the resources that are not ready", func() {
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+				},
+			}
+
+			pipeline, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = handlers.WaitForPipeline(ctx, k8sClient, namespace, pipeline.ID, 2*time.Second)
+			var notReadyErr *handlers.NotReadyError
+			Expect(errors.As(err, &notReadyErr)).To(BeTrue())
+			Expect(notReadyErr.Blocking).To(HaveLen(1))
+			Expect(notReadyErr.Blocking[0].Name).To(BeEquivalentTo(pipeline.Entry.Name))
+			Expect(notReadyErr.Blocking[0].Reasons).NotTo(BeEmpty())
+			Expect(notReadyErr.ReadyResources).To(BeEquivalentTo(0))
+			Expect(notReadyErr.TotalResources).To(BeEquivalentTo(1))
+			Expect(notReadyErr.Nodes).To(HaveLen(2))
+
+			By("still waiting on the services once the resources are ready")
+			sequence := &flows.Sequence{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: pipeline.Entry.Name, Namespace: namespace}, sequence)).To(Succeed())
+			Expect(markReady(ctx, sequence, &sequence.Status.Status)).To(Succeed())
+
+			_, err = handlers.WaitForPipeline(ctx, k8sClient, namespace, pipeline.ID, 2*time.Second)
+			Expect(errors.As(err, &notReadyErr)).To(BeTrue())
+			Expect(notReadyErr.Blocking).To(BeEmpty())
+			Expect(notReadyErr.ReadyResources).To(BeEquivalentTo(1))
+			Expect(notReadyErr.Nodes).To(HaveLen(2))
+			Expect(notReadyErr.Nodes["0"].Reasons).To(ContainElement(ContainSubstring("service func-0")))
+
+			By("returning as soon as everything is ready")
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(500 * time.Millisecond)
+				for _, node := range pipeline.Nodes {
+					ksvc := &serving.Service{}
+					Expect(k8sClient.Get(ctx, types.NamespacedName{Name: node.Data.FaasID, Namespace: namespace}, ksvc)).To(Succeed())
+					Expect(markReady(ctx, ksvc, &ksvc.Status.Status)).To(Succeed())
+				}
+			}()
+
+			started := time.Now()
+			status, err := handlers.WaitForPipeline(ctx, k8sClient, namespace, pipeline.ID, 30*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(started)).To(BeNumerically("<", 10*time.Second))
+			Expect(status.Ready).To(BeTrue())
+			Expect(status.Entry.Name).To(BeEquivalentTo(pipeline.Entry.Name))
+			Expect(status.ReadyResources).To(BeEquivalentTo(1))
+			Expect(status.Blocking).To(BeEmpty())
+		})
+
+		It("should not report a pipeline that does not exist", func() {
+			_, err := handlers.GetPipelineStatus(ctx, k8sClient, namespace, "does-not-exist")
+			Expect(errors.Is(err, handlers.ErrPipelineNotFound)).To(BeTrue())
+		})
+	})
+
+	Context("When selecting a namespace", func() {
+		It("should only accept namespaces that exist", func() {
+			admin := handlers.RequestInfo{User: "admin", Groups: []string{"system:masters"}}
+			Expect(handlers.CheckNamespace(ctx, k8sClient, namespace, admin, "create")).To(Succeed())
+
+			err := handlers.CheckNamespace(ctx, k8sClient, "does-not-exist", admin, "create")
+			Expect(errors.Is(err, handlers.ErrNamespaceNotFound)).To(BeTrue())
+		})
+
+		It("should refuse callers that are not authenticated", func() {
+			err := handlers.CheckNamespace(ctx, k8sClient, namespace, handlers.RequestInfo{}, "create")
+			Expect(errors.Is(err, handlers.ErrUnauthenticated)).To(BeTrue())
+
+			_, err = handlers.Authenticate(ctx, k8sClient, "not-a-token")
+			Expect(errors.Is(err, handlers.ErrUnauthenticated)).To(BeTrue())
+		})
+
+		It("should take the caller from the token the api server issued", func() {
+			serviceAccount := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "pipeline-caller", Namespace: namespace}}
+			Expect(k8sClient.Create(ctx, serviceAccount)).To(Succeed())
+			tokenRequest := &authenticationv1.TokenRequest{}
+			Expect(k8sClient.SubResource("token").Create(ctx, serviceAccount, tokenRequest)).To(Succeed())
+
+			info, err := handlers.Authenticate(ctx, k8sClient, tokenRequest.Status.Token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.User).To(BeEquivalentTo("system:serviceaccount:" + namespace + ":pipeline-caller"))
+			Expect(info.Groups).To(ContainElement("system:serviceaccounts"))
+
+			// the service account has no role in the namespace yet
+			err = handlers.CheckNamespace(ctx, k8sClient, namespace, info, "create")
+			Expect(errors.Is(err, handlers.ErrForbidden)).To(BeTrue())
+
+			Expect(k8sClient.Delete(ctx, serviceAccount)).To(Succeed())
+		})
+
+		It("should refuse callers without access to the namespace", func() {
+			err := handlers.CheckNamespace(ctx, k8sClient, namespace, handlers.RequestInfo{User: "nobody"}, "create")
+			Expect(errors.Is(err, handlers.ErrForbidden)).To(BeTrue())
+		})
+
+		It("should require access to every kind of resource a pipeline deploys", func() {
+			caller := handlers.RequestInfo{User: "carol"}
+			role := &rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "pipeline-deployer", Namespace: namespace},
+				Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"create"}},
+					{APIGroups: []string{"flows.knative.dev"}, Resources: []string{"sequences", "parallels"}, Verbs: []string{"create"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, role)).To(Succeed())
+			binding := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "pipeline-deployer", Namespace: namespace},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: caller.User}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+			}
+			Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, binding)).To(Succeed())
+				Expect(k8sClient.Delete(ctx, role)).To(Succeed())
+			}()
+
+			By("refusing a caller that may create sequences but not the sources")
+			Eventually(func() error {
+				return handlers.CheckNamespace(ctx, k8sClient, namespace, caller, "create")
+			}, 10*time.Second).Should(MatchError(ContainSubstring("cannot create apiserversources")))
+
+			By("allowing the caller once every kind is granted")
+			role.Rules = append(role.Rules,
+				rbacv1.PolicyRule{APIGroups: []string{"eventing.knative.dev"}, Resources: []string{"brokers", "triggers"}, Verbs: []string{"create"}},
+				rbacv1.PolicyRule{APIGroups: []string{"sources.knative.dev"}, Resources: []string{"*"}, Verbs: []string{"create"}},
+				// the backend registered by the spec on custom translators deploys service accounts
+				rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: []string{"create"}},
+			)
+			Expect(k8sClient.Update(ctx, role)).To(Succeed())
+			Eventually(func() error {
+				return handlers.CheckNamespace(ctx, k8sClient, namespace, caller, "create")
+			}, 10*time.Second).Should(Succeed())
+
+			By("checking the extra kinds asked for, such as the workflow templates an export applies")
+			err := handlers.CheckNamespace(ctx, k8sClient, namespace, caller, "create", schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "WorkflowTemplate"})
+			Expect(errors.Is(err, handlers.ErrForbidden)).To(BeTrue())
+		})
+
+		It("should require the caller to impersonate the service account of an api server source", func() {
+			caller := handlers.RequestInfo{User: "dave"}
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "api", Type: model.ApiServerSourceNode, Data: model.NodeData{Label: "API Server Source", ApiServerSource: &model.ApiServerSourceConfig{
+						ServiceAccountName: "cluster-watcher",
+						Resources:          []model.SourceResource{{APIVersion: "v1", Kind: "Event"}},
+					}}},
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+				},
+				Edges: []model.Edge{
+					{ID: "api-0", Source: "api", Target: "0"},
+				},
+			}
+
+			err := handlers.CheckServiceAccounts(ctx, k8sClient, namespace, caller, pipelinePayload)
+			Expect(err).To(MatchError(ContainSubstring("cannot impersonate serviceaccounts cluster-watcher")))
+			Expect(errors.Is(err, handlers.ErrForbidden)).To(BeTrue())
+
+			role := &rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster-watcher-user", Namespace: namespace},
+				Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, ResourceNames: []string{"cluster-watcher"}, Verbs: []string{"impersonate"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, role)).To(Succeed())
+			binding := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster-watcher-user", Namespace: namespace},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: caller.User}},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+			}
+			Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, binding)).To(Succeed())
+				Expect(k8sClient.Delete(ctx, role)).To(Succeed())
+			}()
+
+			Eventually(func() error {
+				return handlers.CheckServiceAccounts(ctx, k8sClient, namespace, caller, pipelinePayload)
+			}, 10*time.Second).Should(Succeed())
+		})
+	})
+
+	Context("When wiring the pipeline together", func() {
+		It("should route sequences into parallels and report the entry", func() {
+			/*
+				0 -> 1 -> 2
+				     |
+				     V
+				     3
+
+				0 -> 1 should reply into the parallel for 1, which fans out to 2 and 3
+			*/
+			pipelinePayload := model.PipelinePayload{
+				Nodes: []model.Node{
+					{ID: "0", Data: model.NodeData{Label: "func-0", FaasID: "func-0"}},
+					{ID: "1", Data: model.NodeData{Label: "func-1", FaasID: "func-1"}},
+					{ID: "2", Data: model.NodeData{Label: "func-2", FaasID: "func-2"}},
+					{ID: "3", Data: model.NodeData{Label: "func-3", FaasID: "func-3"}},
+				},
+				Edges: []model.Edge{
+					{ID: "0-1", Source: "0", Target: "1"},
+					{ID: "1-2", Source: "1", Target: "2"},
+					{ID: "1-3", Source: "1", Target: "3"},
+				},
+			}
+
+			deployed, err := handlers.ProcessPayload(k8sClient, ctx, pipelinePayload, namespace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployed.Parallels).To(HaveLen(1))
+
+			parallelName := deployed.Parallels[0].Name
+			entrySequence := pipelinePayload.Nodes[0].SequenceId
+			Expect(deployed.Entry).To(BeEquivalentTo(&model.PipelineEntry{Kind: "Sequence", Name: entrySequence}))
+
+			sequence, err := getSequence(ctx, entrySequence)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequence.Spec.Steps).To(HaveLen(2))
+			Expect(sequence.Spec.Reply.Ref.Kind).To(BeEquivalentTo("Parallel"))
+			Expect(sequence.Spec.Reply.Ref.Name).To(BeEquivalentTo(parallelName))
+
+			// the branches are the end of the pipeline
+			for _, nodeIndex := range []int{2, 3} {
+				branch, err := getSequence(ctx, pipelinePayload.Nodes[nodeIndex].SequenceId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(branch.Spec.Reply).To(BeNil())
+			}
+
+			pipeline, err := handlers.GetPipeline(ctx, k8sClient, namespace, deployed.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pipeline.Entry.Name).To(BeEquivalentTo(entrySequence))
+		})
+	})
+})
+
+func createKsvc(ctx context.Context, funcName string) error {
+	ksvc := &serving.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      funcName,
+			Namespace: namespace,
+		},
+		Spec: serving.ServiceSpec{
+			ConfigurationSpec: serving.ConfigurationSpec{
+				Template: serving.RevisionTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{},
+					Spec: serving.RevisionSpec{
+						PodSpec: v1.PodSpec{
+							Containers: []v1.Container{},
+						},
+					},
+				},
+			},
+		},
+	}
+	return k8sClient.Create(ctx, ksvc)
+}
+
+func deleteKsvc(ctx context.Context, funcName string) error {
+	ksvc := &serving.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      funcName,
+			Namespace: namespace,
+		},
+		Spec: serving.ServiceSpec{
+			ConfigurationSpec: serving.ConfigurationSpec{
+				Template: serving.RevisionTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{},
+					Spec: serving.RevisionSpec{
+						PodSpec: v1.PodSpec{
+							Containers: []v1.Container{},
+						},
+					},
+				},
+			},
+		},
+	}
+	return k8sClient.Delete(ctx, ksvc)
+}
+
+func getKsvc(ctx context.Context, funcName string) (*serving.Service, error) {
+	ksvc := &serving.Service{}
+	typeNamespacedName := types.NamespacedName{
+		Name:      funcName,
+		Namespace: namespace,
+	}
+	err := k8sClient.Get(ctx, typeNamespacedName, ksvc)
+	return ksvc, err
+}
+
+func getSequence(ctx context.Context, sequenceName string) (*flows.Sequence, error) {
+	ksequence := &flows.Sequence{}
+	typeNamespacedName := types.NamespacedName{
+		Name:      sequenceName,
+		Namespace: namespace,
+	}
+	err := k8sClient.Get(ctx, typeNamespacedName, ksequence)
+	return ksequence, err
+}
+
+func getSequenceList(ctx context.Context) (*flows.SequenceList, error) {
+	ksequenceList := &flows.SequenceList{}
+	err := k8sClient.List(ctx, ksequenceList)
+	return ksequenceList, err
+}
+
+func getParallelList(ctx context.Context) (*flows.ParallelList, error) {
+	kparallelList := &flows.ParallelList{}
+	err := k8sClient.List(ctx, kparallelList)
+	return kparallelList, err
+}
+
+func deleteAllKsvc(ctx context.Context) error {
+	for _, faasId := range testFaasList{
+		if err := deleteKsvc(ctx, faasId); err != nil {
+			return fmt.Errorf("failed to delete faas %s in namespace %s: %w",faasId, namespace, err)
+		}
+	}
+	return nil
+}
+
+func deleteAllSequences(ctx context.Context) error {
+	// Define a list to hold all Knative Sequences
+	sequenceList := &flows.SequenceList{}
+	// List all sequences in the given namespace
+	if err := k8sClient.List(ctx, sequenceList, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to list sequences in namespace %s: %w", namespace, err)
+	}
+
+	// Iterate through each sequence and delete it
+	for _, sequence := range sequenceList.Items {
+		if err := k8sClient.Delete(ctx, &sequence); err != nil {
+			return fmt.Errorf("failed to delete sequence %s in namespace %s: %w", sequence.Name, namespace, err)
+		}
+	}
+	return nil
+}
+
+func deleteAllParallels(ctx context.Context) error {
+	// Define a list to hold all Knative parallels
+	parallelList := &flows.ParallelList{}
+	// List all parallels in the given namespace
+	if err := k8sClient.List(ctx, parallelList, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to list parallels in namespace %s: %w", namespace, err)
+	}
+
+	// Iterate through each sequence and delete it
+	for _, parallel := range parallelList.Items {
+		if err := k8sClient.Delete(ctx, &parallel); err != nil {
+			return fmt.Errorf("failed to delete parallel %s in namespace %s: %w", parallel.Name, namespace, err)
+		}
+	}
+	return nil
+}
+
+var testSourceKinds = []string{"ApiServerSource", "ContainerSource", "PingSource", "SinkBinding"}
+
+func getSourceList(ctx context.Context, kind string) (*unstructured.UnstructuredList, error) {
+	sourceList := &unstructured.UnstructuredList{}
+	sourceList.SetGroupVersionKind(schema.GroupVersionKind{Group: "sources.knative.dev", Version: "v1", Kind: kind + "List"})
+	err := k8sClient.List(ctx, sourceList, client.InNamespace(namespace))
+	return sourceList, err
+}
+
+func deleteAllSources(ctx context.Context) error {
+	for _, kind := range testSourceKinds {
+		source := &unstructured.Unstructured{}
+		source.SetGroupVersionKind(schema.GroupVersionKind{Group: "sources.knative.dev", Version: "v1", Kind: kind})
+		if err := k8sClient.DeleteAllOf(ctx, source, client.InNamespace(namespace)); err != nil {
+			return fmt.Errorf("failed to delete %s in namespace %s: %w", kind, namespace, err)
+		}
+	}
+	return nil
+}
+
+// singleSequenceTranslator puts every function of the graph in one sequence, ignoring the edges, and gives it a
+// service account, a kind the handlers know nothing about
+type singleSequenceTranslator struct{}
+
+func (singleSequenceTranslator) Validate(ctx context.Context, k8sClient client.Client, payload model.PipelinePayload) []model.ValidationError {
+	return nil
+}
+
+func (singleSequenceTranslator) Kinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{
+		flows.SchemeGroupVersion.WithKind("Sequence"),
+		v1.SchemeGroupVersion.WithKind("ServiceAccount"),
+	}
+}
+
+func (singleSequenceTranslator) Translate(ctx context.Context, k8sClient client.Client, graph *handlers.Graph) ([]client.Object, error) {
+	faasIds := []string{}
+	for _, node := range graph.Functions {
+		faasIds = append(faasIds, node.Data.FaasID)
+	}
+	sequence := handlers.TranslateSequence(faasIds, graph.Namespace, graph.PipelineID+"-all")
+	sequence.Annotations = map[string]string{handlers.EntryAnnotation: "true"}
+	serviceAccount := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: graph.PipelineID + "-all"}}
+	return []client.Object{&sequence, serviceAccount}, nil
+}
+
+func deleteAllBrokers(ctx context.Context) error {
+	if err := k8sClient.DeleteAllOf(ctx, &eventing.Trigger{}, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to delete triggers in namespace %s: %w", namespace, err)
+	}
+	if err := k8sClient.DeleteAllOf(ctx, &eventing.Broker{}, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to delete brokers in namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// setKsvcAddress fills in the cluster local address knative gives a ksvc once it is reconciled
+func setKsvcAddress(ctx context.Context, funcName string) error {
+	return setKsvcURL(ctx, funcName, apis.HTTP(funcName+"."+namespace+".svc.cluster.local"))
+}
+
+// setKsvcURL points the address of a ksvc somewhere else, such as a test server
+func setKsvcURL(ctx context.Context, funcName string, url *apis.URL) error {
+	ksvc, err := getKsvc(ctx, funcName)
+	if err != nil {
+		return err
+	}
+	ksvc.Status.Address = &duck.Addressable{URL: url}
+	return k8sClient.Status().Update(ctx, ksvc)
+}
+
+// functionServer stands in for a function, it replies with the data it got followed by its id.
+// With a reply type it answers with a CloudEvent of that type, otherwise with a plain body.
+func functionServer(id string, replyType string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if replyType != "" {
+			w.Header().Set("Ce-Id", id+"-"+r.Header.Get("Ce-Id"))
+			w.Header().Set("Ce-Source", id)
+			w.Header().Set("Ce-Type", replyType)
+		}
+		_, _ = w.Write([]byte(string(body) + ">" + id))
+	}))
+	DeferCleanup(server.Close)
+	return server
+}
+
+// workflowTemplates returns the templates of an exported workflow by name
+func workflowTemplates(workflow unstructured.Unstructured) map[string]map[string]interface{} {
+	templates := map[string]map[string]interface{}{}
+	list, _, _ := unstructured.NestedSlice(workflow.Object, "spec", "templates")
+	for _, item := range list {
+		template := item.(map[string]interface{})
+		templates[template["name"].(string)] = template
+	}
+	return templates
+}
+
+// workflowTasks returns the DAG tasks of an exported workflow by name
+func workflowTasks(workflow unstructured.Unstructured) map[string]map[string]interface{} {
+	tasks := map[string]map[string]interface{}{}
+	list, _, _ := unstructured.NestedSlice(workflowTemplates(workflow)["pipeline"], "dag", "tasks")
+	for _, item := range list {
+		task := item.(map[string]interface{})
+		tasks[task["name"].(string)] = task
+	}
+	return tasks
+}
+
+func deleteAllWorkflows(ctx context.Context) error {
+	workflow := &unstructured.Unstructured{}
+	workflow.SetGroupVersionKind(schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "WorkflowTemplate"})
+	if err := k8sClient.DeleteAllOf(ctx, workflow, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to delete workflow templates in namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// markReady stands in for the knative controllers, which do not run in the test env
+func markReady(ctx context.Context, obj client.Object, status *duck.Status) error {
+	status.ObservedGeneration = obj.GetGeneration()
+	status.Conditions = duck.Conditions{
+		{Type: apis.ConditionReady, Status: v1.ConditionTrue, LastTransitionTime: apis.VolatileTime{Inner: metav1.Now()}},
+	}
+	return k8sClient.Status().Update(ctx, obj)
+}
+
+func deleteAllGraphs(ctx context.Context) error {
+	// graphs are stored in configmaps labelled with their pipeline id
+	err := k8sClient.DeleteAllOf(ctx, &v1.ConfigMap{},
+		client.InNamespace(namespace), client.HasLabels{handlers.PipelineIdLabel})
+	if err != nil {
+		return fmt.Errorf("failed to delete pipeline graphs in namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+func getActualOutput(pipelinePayload model.PipelinePayload) map[string]interface{} {
+	nodes := pipelinePayload.Nodes
+	edges := pipelinePayload.Edges
+
+	parallels, sequences := helpers.TraverseGraph(nodes, edges)
+
+	return map[string]interface{}{
+		"parallels": parallels,
+		"sequences": sequences,
+	}
+}